@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// interrupter gives Ctrl+C, SIGTERM and the spinner's quit key a single
+// meaning: the first one cancels the roast in progress, and one that arrives
+// with nothing left to cancel exits.
+type interrupter struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	signals chan os.Signal
+}
+
+// newInterrupter starts listening for SIGINT and SIGTERM
+func newInterrupter() *interrupter {
+	i := &interrupter{signals: make(chan os.Signal, 1)}
+	signal.Notify(i.signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		for range i.signals {
+			i.interrupt()
+		}
+	}()
+
+	return i
+}
+
+// begin returns a context for a new roast that the next interrupt cancels
+func (i *interrupter) begin() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	i.mu.Lock()
+	i.cancel = cancel
+	i.mu.Unlock()
+
+	return ctx
+}
+
+// end marks the roast as finished, so the next interrupt exits
+func (i *interrupter) end() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.cancel != nil {
+		i.cancel()
+		i.cancel = nil
+	}
+}
+
+// interrupt cancels the roast in progress, or exits if there isn't one
+func (i *interrupter) interrupt() {
+	i.mu.Lock()
+	cancel := i.cancel
+	i.cancel = nil
+	i.mu.Unlock()
+
+	if cancel == nil {
+		fmt.Println("\nExiting GoRoastMe. Your terminal is safe... for now.")
+		os.Exit(0)
+	}
+	cancel()
+}
+
+// stop stops listening for signals
+func (i *interrupter) stop() {
+	signal.Stop(i.signals)
+}
@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,6 +13,7 @@ import (
 	"github.com/jasonlovesdoggo/roastme/internal/analysis"
 	"github.com/jasonlovesdoggo/roastme/internal/config"
 	"github.com/jasonlovesdoggo/roastme/internal/history"
+	"github.com/jasonlovesdoggo/roastme/internal/redact"
 	"github.com/jasonlovesdoggo/roastme/internal/ui"
 	"github.com/spf13/cobra"
 )
@@ -19,6 +23,15 @@ var (
 	deep         bool
 	complexity   string
 	commandLimit int
+	shell        string
+	historyFiles []string
+
+	showRedactions bool
+	dryRun         bool
+	jsonOutput     bool
+	noFallback     bool
+	seed           int64
+	persona        string
 )
 
 var rootCmd = &cobra.Command{
@@ -29,6 +42,38 @@ and generates endless, hilarious roasts about your terminal habits.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Configure the application
 		cfg := config.GetConfig()
+		if noFallback {
+			cfg.AI.NoFallback = true
+		}
+		if seed != 0 {
+			cfg.AI.Seed = seed
+		}
+		if persona != "" {
+			cfg.AI.Persona = persona
+		}
+
+		// Catch a typo in the persona or a broken template before roasting
+		if err := ai.CheckPrompts(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := ai.CheckRoastPacks(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if showRedactions {
+			runShowRedactions(cfg)
+			return
+		}
+
+		if dryRun {
+			if err := runDryRun(cfg); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
 
 		// Run the interactive roasting mode
 		runInteractiveMode(cfg)
@@ -45,53 +90,117 @@ var configCmd = &cobra.Command{
 
 func runInteractiveMode(cfg config.Config) {
 	fmt.Println("GoRoastMe - Terminal History Roaster")
-	fmt.Println("Press Enter for a new roast, Ctrl+C to exit")
+	fmt.Println("Press Enter for a new roast, type to talk back, Ctrl+C to stop a roast or exit")
 	fmt.Println()
 
 	reader := bufio.NewReader(os.Stdin)
 	count := 0
+	waitForInput := false
+
+	// Ctrl+C cancels the roast in progress; pressing it again exits
+	interrupts := newInterrupter()
+	defer interrupts.stop()
+
+	// Keep parsed history between roasts so unchanged files aren't reparsed
+	cacheDir := ""
+	if cfg.History.DiskCache {
+		if dir, err := history.DefaultCacheDir(); err == nil {
+			cacheDir = dir
+		}
+	}
+	historyCache := history.NewCache(cacheDir)
+	history.SetCache(historyCache)
+
+	// Analysis is only redone when the history changes, and then only for
+	// the commands that were added
+	analyzer := analysis.NewAnalyzer()
+	var patterns analysis.CommandPattern
+	analyzedGeneration := -1
+
+	// Roasts and replies build on everything said so far
+	conversation := ai.NewConversation(cfg)
 
 	for {
-		// Generate initial roast or wait for Enter for subsequent roasts
-		if count > 0 {
-			fmt.Print("\nPress Enter for another roast... ")
+		// Generate initial roast or wait for Enter or a reply for subsequent roasts
+		message := ""
+		if waitForInput {
+			fmt.Print("\nPress Enter for another roast, or talk back: ")
 			input, _ := reader.ReadString('\n')
-			input = strings.TrimSpace(input)
+			message = strings.TrimSpace(input)
 
 			// Exit if user types "exit" or "quit"
-			if strings.ToLower(input) == "exit" || strings.ToLower(input) == "quit" {
+			if strings.ToLower(message) == "exit" || strings.ToLower(message) == "quit" {
 				fmt.Println("Exiting GoRoastMe. Your terminal is safe... for now.")
 				return
 			}
 		}
 
+		ctx := interrupts.begin()
+
 		// Create a spinner
 		spinner := ui.NewSpinner("Analyzing your command history...")
+		spinner.OnQuit(interrupts.interrupt)
 		spinner.Start()
 
 		// Calculate actual command limit
-		actualLimit := commandLimit
-		if deep {
-			actualLimit *= 5 // Multiply by 5 in deep mode
-		}
+		actualLimit := getCommandLimit()
 
 		// Get command history - using actualLimit
-		commands, err := history.GetShellHistory(actualLimit)
+		entries, err := loadHistory(ctx, actualLimit)
 		if err != nil {
 			spinner.Stop()
+			if ctx.Err() != nil {
+				interrupts.end()
+				fmt.Println("\nRoast cancelled.")
+				waitForInput = true
+				continue
+			}
 			fmt.Fprintf(os.Stderr, "Error getting shell history: %v\n", err)
-			continue
+			return
 		}
+		commands := history.Commands(entries)
 
 		// Analyze command patterns
-		patterns := analysis.AnalyzeHistory(commands)
+		if generation := historyCache.Generation(); generation != analyzedGeneration {
+			patterns = analyzer.Update(entries)
+			analyzedGeneration = generation
+		}
+
+		// Show the roast as it is generated, replacing the spinner once text arrives
+		stream := ui.NewRoastStream(count+1, len(commands))
+		onToken := func(text string) {
+			spinner.Stop()
+			stream.Update(text)
+		}
 
-		// Generate roast with selected complexity
+		// Generate a roast with selected complexity, or answer what the user said
 		level := getComplexityLevel()
-		roast, err := ai.GenerateRoast(cfg, patterns, commands, level)
+		var roast ai.Roast
+		if message == "" {
+			roast, err = conversation.Roast(ctx, patterns, commands, level, onToken)
+		} else {
+			roast, err = conversation.Reply(ctx, message, patterns, commands, level, onToken)
+		}
+		cancelled := ctx.Err() != nil
+		interrupts.end()
 		if err != nil {
 			spinner.Stop()
+			if cancelled {
+				fmt.Println("\nRoast cancelled.")
+				waitForInput = true
+				continue
+			}
+			if errors.Is(err, ai.ErrNoConversation) {
+				fmt.Println("Talking back needs an AI provider; the built-in roasts can't hear you. Press Enter for another roast.")
+				waitForInput = true
+				continue
+			}
 			fmt.Fprintf(os.Stderr, "Error generating roast: %v\n", err)
+			if hint := ai.ErrorHint(err); hint != "" {
+				fmt.Fprintln(os.Stderr, hint)
+			}
+			// The provider may recover or the user may want to quit, so let them choose
+			waitForInput = true
 			continue
 		}
 
@@ -101,7 +210,66 @@ func runInteractiveMode(cfg config.Config) {
 		ui.DisplayInteractiveRoast(roast, count+1, len(commands))
 
 		count++
+		waitForInput = true
+	}
+}
+
+// loadHistory reads the history selected by the --shell and --history-file flags
+func loadHistory(ctx context.Context, limit int) ([]history.CommandEntry, error) {
+	sources, err := history.SelectSources(shell, historyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return history.ReadSources(ctx, sources, limit)
+}
+
+// runShowRedactions prints what redaction would change before commands are sent to an AI provider
+func runShowRedactions(cfg config.Config) {
+	entries, err := loadHistory(context.Background(), getCommandLimit())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting shell history: %v\n", err)
+		return
+	}
+
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading redaction rules: %v\n", err)
+		return
+	}
+
+	ui.DisplayRedactions(redactor.PreviewAll(history.Commands(entries)), len(entries), cfg.Redaction.Enabled)
+}
+
+// runDryRun prints the exact request that would be sent to the AI provider without sending it
+func runDryRun(cfg config.Config) error {
+	entries, err := loadHistory(context.Background(), getCommandLimit())
+	if err != nil {
+		return fmt.Errorf("error getting shell history: %v", err)
+	}
+
+	patterns := analysis.AnalyzeEntries(entries)
+	req, err := ai.BuildRequest(cfg, patterns, history.Commands(entries), getComplexityLevel())
+	if err != nil {
+		return fmt.Errorf("error building prompt: %v", err)
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(req)
+	}
+
+	ui.DisplayDryRun(req)
+	return nil
+}
+
+// getCommandLimit returns how many commands to analyze
+func getCommandLimit() int {
+	if deep {
+		return commandLimit * 5 // Multiply by 5 in deep mode
 	}
+	return commandLimit
 }
 
 func getComplexityLevel() ai.ComplexityLevel {
@@ -130,6 +298,14 @@ func init() {
 	rootCmd.Flags().BoolVar(&deep, "deep", false, "Analyze 5x more commands than the default limit")
 	rootCmd.Flags().StringVar(&complexity, "complexity", "normal", "Roast complexity: simple, normal, complex, or brutal")
 	rootCmd.Flags().IntVar(&commandLimit, "limit", 500, "Number of commands to analyze (multiplied by 5 in deep mode)")
+	rootCmd.Flags().StringVar(&shell, "shell", "", "History source to read: "+strings.Join(history.SourceNames(), ", ")+", a comma separated list, or \"all\" (detected from $SHELL by default)")
+	rootCmd.Flags().BoolVar(&showRedactions, "show-redactions", false, "Preview which secrets would be redacted before sending commands to an AI provider, then exit")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the exact prompt and generation parameters that would be sent to the AI provider, then exit")
+	rootCmd.Flags().BoolVar(&noFallback, "no-fallback", false, "Exit with an error instead of using a built-in roast when every AI provider fails")
+	rootCmd.Flags().StringVar(&persona, "persona", "", "Persona to roast you as: "+strings.Join(ai.Personas(), ", ")+", or your own from ~/.config/roastme/prompts/personas")
+	rootCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for choosing which commands are sent to the AI, so the same history gives the same sample")
+	rootCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print --dry-run output as JSON")
+	rootCmd.Flags().StringSliceVar(&historyFiles, "history-file", nil, "History file to read, optionally prefixed with its source (e.g. zsh:/path/to/.zsh_history); repeatable")
 
 	rootCmd.AddCommand(configCmd)
 }
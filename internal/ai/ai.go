@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/jasonlovesdoggo/roastme/internal/analysis"
 	"github.com/jasonlovesdoggo/roastme/internal/config"
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/googleai"
 	"github.com/tmc/langchaingo/llms/openai"
 )
@@ -19,25 +23,87 @@ const (
 	NormalRoast
 	ComplexRoast
 	BrutalRoast
-	systemPrompt string = "You are an Arch Linux user who lives in your terminal. " +
-		"Your only purpose is to roast people about their command-line habits.\nBe clever, humorous, and unapologetically savage. Analyze shell history with deep technical insight, and deliver biting, hilarious roasts.\nThink of yourself as the Gordon Ramsay of the terminal—brutal but constructive. Your tone is sharp, witty, and tech-savvy, always packed\nwith programming humor."
 )
 
-// GenerateRoast generates a roast based on the command patterns
-func GenerateRoast(cfg config.Config, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel) (string, error) {
-	// Use local roasts if no AI provider is configured or provider is set to "local"
-	if cfg.AI.Provider == "" || cfg.AI.Provider == "local" {
-		return generateLocalRoast(patterns, complexity), nil
+// Roast is a generated roast along with how it was produced
+type Roast struct {
+	Text string
+	// Provider and Model are what actually answered; Provider is "local" for built-in roasts
+	Provider string
+	Model    string
+	// Fallback holds the errors from providers earlier in the chain that failed, if any
+	Fallback error
+}
+
+// StreamFunc receives the roast text generated so far, each time more of it
+// arrives. It is called with an empty string when a failed attempt's partial
+// output should be thrown away.
+type StreamFunc func(text string)
+
+// GenerateRoast generates a roast based on the command patterns, trying each
+// provider in the chain until one answers. If they all fail, a built-in roast
+// is returned with the failures recorded in Roast.Fallback, unless
+// ai.no_fallback is set, in which case the error is returned.
+// If stream is not nil, AI roasts are passed to it as they are generated.
+func GenerateRoast(ctx context.Context, cfg config.Config, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel, stream StreamFunc) (Roast, error) {
+	roast, _, err := runChain(ctx, cfg, patterns, commands, complexity, stream, nil, func(providerCfg config.Config) (Request, error) {
+		return BuildRequest(providerCfg, patterns, commands, complexity)
+	})
+	return roast, err
+}
+
+// runChain tries each provider in the chain with the request build assembles
+// for it, falling back as GenerateRoast describes. With a session memory,
+// built-in roasts avoid ones already delivered. It also returns the request
+// that was answered, which is empty for built-in roasts.
+func runChain(ctx context.Context, cfg config.Config, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel,
+	stream StreamFunc, memory *sessionMemory, build func(config.Config) (Request, error)) (Roast, Request, error) {
+	if err := ctx.Err(); err != nil {
+		return Roast{}, Request{}, err
 	}
 
-	// Try to generate a roast using the configured AI provider
-	roast, err := generateAIRoast(cfg, patterns, commands, complexity)
-	if err != nil {
-		// Fall back to local roasts if AI fails
-		return generateLocalRoast(patterns, complexity), nil
+	var failures []error
+
+	for i, provider := range providerChain(cfg) {
+		if provider == "local" {
+			// With fallback disabled, built-in roasts are only used when asked for first
+			if i > 0 && cfg.AI.NoFallback {
+				continue
+			}
+			return Roast{Text: memory.localRoast(patterns, commands, complexity), Provider: "local", Fallback: errors.Join(failures...)}, Request{}, nil
+		}
+
+		providerCfg := withProvider(cfg, provider)
+
+		// Assemble exactly what will be sent, with secrets scrubbed
+		req, err := build(providerCfg)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", provider, err))
+			continue
+		}
+
+		text, err := generateWithRetries(ctx, providerCfg, req, stream)
+		if err == nil {
+			return Roast{Text: text, Provider: provider, Model: req.Model, Fallback: errors.Join(failures...)}, req, nil
+		}
+
+		// Nobody wants the next provider tried after they've cancelled
+		if ctx.Err() != nil {
+			return Roast{}, Request{}, err
+		}
+		failures = append(failures, err)
 	}
 
-	return roast, nil
+	roast, err := fallbackRoast(cfg, patterns, commands, complexity, memory, errors.Join(failures...))
+	return roast, Request{}, err
+}
+
+// fallbackRoast returns a built-in roast after a provider failure, or the failure itself if fallback is disabled
+func fallbackRoast(cfg config.Config, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel, memory *sessionMemory, err error) (Roast, error) {
+	if cfg.AI.NoFallback {
+		return Roast{}, err
+	}
+	return Roast{Text: memory.localRoast(patterns, commands, complexity), Provider: "local", Fallback: err}, nil
 }
 
 // initOpenAI initializes the OpenAI client
@@ -61,18 +127,43 @@ func initOpenAI(cfg config.Config) (llms.Model, error) {
 	return openai.New(options...)
 }
 
-// initAnthropic initializes the Anthropic client
+// initAnthropic initializes the Anthropic client, which talks to the Messages API
 func initAnthropic(cfg config.Config) (llms.Model, error) {
-	return nil, errors.New("anthropic support not implemented yet")
+	if cfg.AI.Anthropic.APIKey == "" {
+		return nil, errors.New("anthropic API key not configured")
+	}
+
+	options := []anthropic.Option{
+		anthropic.WithToken(cfg.AI.Anthropic.APIKey),
+	}
+
+	if cfg.AI.Anthropic.BaseURL != "" {
+		options = append(options, anthropic.WithBaseURL(anthropicBaseURL(cfg.AI.Anthropic.BaseURL)))
+	}
+
+	if cfg.AI.Anthropic.Model != "" {
+		options = append(options, anthropic.WithModel(cfg.AI.Anthropic.Model))
+	}
+
+	return anthropic.New(options...)
+}
+
+// anthropicBaseURL adds the API version to a bare host like https://api.anthropic.com,
+// since the client appends endpoint paths such as /messages directly
+func anthropicBaseURL(baseURL string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if !strings.HasSuffix(baseURL, "/v1") {
+		baseURL += "/v1"
+	}
+	return baseURL
 }
 
 // initGemini initializes the Google Gemini client
-func initGemini(cfg config.Config) (llms.Model, error) {
+func initGemini(ctx context.Context, cfg config.Config) (llms.Model, error) {
 	if cfg.AI.Gemini.APIKey == "" {
 		return nil, errors.New("google Gemini API key not configured")
 	}
 
-	ctx := context.Background()
 	options := []googleai.Option{
 		googleai.WithAPIKey(cfg.AI.Gemini.APIKey),
 	}
@@ -80,11 +171,6 @@ func initGemini(cfg config.Config) (llms.Model, error) {
 	return googleai.New(ctx, options...)
 }
 
-// initCustom initializes a custom LLM client
-func initCustom(cfg config.Config) (llms.Model, error) {
-	return nil, errors.New("custom LLM provider support not implemented yet")
-}
-
 // formatCommands formats a slice of commands for inclusion in the prompt
 func formatCommands(commands []string) string {
 	result := ""
@@ -94,69 +180,31 @@ func formatCommands(commands []string) string {
 	return result
 }
 
-// createPromptForComplexity creates a prompt based on the desired complexity level
-func createPromptForComplexity(commands []string, patterns analysis.CommandPattern,
-	complexity ComplexityLevel, totalCommandsAnalyzed int) string {
-	basePrompt := fmt.Sprintf(`
-Analysis of %d total commands. Sample commands:
-%s
-
-Patterns found:
-- Repeated commands: %v
-- Failed commands: %v
-- Complex commands: %v
-- Indecisive: %v
-- Time wasters: %v
-- Skill level: %s
-`, totalCommandsAnalyzed, formatCommands(commands),
-		patterns.RepeatedCommands, patterns.FailedCommands, patterns.ComplexCommands,
-		patterns.Indecisive, patterns.TimeWasters, patterns.SkillLevel)
-
-	switch complexity {
-	case SimpleRoast:
-		return systemPrompt + "Roast this person based on their command line history. Be concise and mildly amusing." +
-			basePrompt + "\nGenerate a short, simple roast (1-2 sentences) about their terminal habits."
-
-	case NormalRoast:
-		return systemPrompt + "Roast this person based on their command line history. Be funny but not mean." +
-			basePrompt + "\nGenerate a moderate-length roast (2-3 sentences) about their terminal habits."
-
-	case ComplexRoast:
-		return systemPrompt + "Roast this person based on their command line history. Be clever, " +
-			"insightful and humorous." +
-			basePrompt +
-			"\nGenerate a detailed roast (3-4 paragraphs) about their terminal habits. Include specific observations about their " +
-			"command patterns, technical skill level, and potential personality traits that might be revealed by their commands. " +
-			"Be creative and witty, using tech humor and programming references."
-
-	case BrutalRoast:
-		return systemPrompt + "Roast this person based on their command line history. Be extremely thorough, devastatingly funny, and borderline ruthless." +
-			basePrompt +
-			"\nWrite a comprehensive, brutal roast (4+ paragraphs) that thoroughly analyzes their terminal habits. " +
-			"Include specific references to their commands, create an entire psychological profile based on their terminal behavior, " +
-			"make wild assumptions about their coding abilities, and don't hold back on the technical humor. " +
-			"Imagine this is a Comedy Central Roast but for developers. Be creative, savage but still ultimately good-natured."
+// formatShells describes how the history was split across shells, or returns
+// an empty string if it all came from one shell
+func formatShells(shells map[string]int) string {
+	if len(shells) < 2 {
+		return ""
 	}
 
-	return systemPrompt + "Roast this person based on their command line history." + basePrompt
-}
-
-// generateAIRoast generates a roast using the configured AI provider
-func generateAIRoast(cfg config.Config, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel) (string, error) {
-	// Prepare context
-	ctx := context.Background()
-
-	// For large command sets, we'll implement smart sampling to ensure AI gets good context
-	// without hitting token limits
-
-	// First, ensure we have a good representation of the command history
-	sampledCommands := sampleCommands(commands, complexity)
+	names := make([]string, 0, len(shells))
+	for name := range shells {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return shells[names[i]] > shells[names[j]]
+	})
 
-	// Create prompt based on command patterns and complexity level
-	// Include metadata about the total analysis scope
-	prompt := createPromptForComplexity(sampledCommands, patterns, complexity, len(commands))
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s (%d commands)", name, shells[name]))
+	}
+	return "- Shells used: " + strings.Join(parts, ", ") + "\n"
+}
 
-	var llm llms.LLM
+// generateAIRoast sends the assembled request to the configured AI provider
+func generateAIRoast(ctx context.Context, cfg config.Config, req Request, stream StreamFunc) (string, error) {
+	var llm llms.Model
 	var err error
 
 	// Initialize the appropriate LLM based on provider
@@ -166,9 +214,11 @@ func generateAIRoast(cfg config.Config, patterns analysis.CommandPattern, comman
 	case "anthropic":
 		llm, err = initAnthropic(cfg)
 	case "gemini":
-		llm, err = initGemini(cfg)
+		llm, err = initGemini(ctx, cfg)
 	case "custom":
 		llm, err = initCustom(cfg)
+	case "ollama":
+		llm, err = initOllama(ctx, cfg)
 	default:
 		return "", errors.New("unsupported AI provider")
 	}
@@ -177,106 +227,62 @@ func generateAIRoast(cfg config.Config, patterns analysis.CommandPattern, comman
 		return "", err
 	}
 
-	// Generate the roast with appropriate parameters based on complexity
-	maxTokens := 150
-	temperature := 0.7
-
-	switch complexity {
-	case SimpleRoast:
-		maxTokens = 100
-		temperature = 0.5
-	case NormalRoast:
-		maxTokens = 150
-		temperature = 0.7
-	case ComplexRoast:
-		maxTokens = 300
-		temperature = 0.8
-	case BrutalRoast:
-		maxTokens = 500
-		temperature = 0.9
+	options := []llms.CallOption{
+		llms.WithTemperature(req.Temperature),
+		llms.WithMaxTokens(req.MaxTokens),
 	}
 
-	completion, err := llm.Call(ctx, prompt,
-		llms.WithTemperature(temperature),
-		llms.WithMaxTokens(maxTokens),
-	)
-
-	if err != nil {
-		return "", err
+	if stream != nil && canStream(cfg) {
+		var text strings.Builder
+		options = append(options, llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+			text.Write(chunk)
+			stream(text.String())
+			return nil
+		}))
 	}
 
-	return completion, nil
-}
-
-// sampleCommands intelligently samples commands from a potentially large history
-// to ensure we get representative commands without overwhelming the AI with tokens
-func sampleCommands(commands []string, complexity ComplexityLevel) []string {
-	if len(commands) <= 50 {
-		// For small command sets, use everything
-		return commands
+	// These providers take the system prompt as a real system message, and the
+	// earlier turns of a conversation have to be sent as messages to any provider
+	switch {
+	case len(req.History) > 0:
+		return generateWithSystemMessage(ctx, llm, req, options...)
+	case cfg.AI.Provider == "anthropic", cfg.AI.Provider == "custom", cfg.AI.Provider == "ollama":
+		return generateWithSystemMessage(ctx, llm, req, options...)
 	}
 
-	// Otherwise, do strategic sampling based on complexity level
-
-	var sampledCommands []string
-
-	// The number of commands to sample depends on complexity
-	var sampleSize int
-	switch complexity {
-	case SimpleRoast:
-		sampleSize = 50
-	case NormalRoast:
-		sampleSize = 80
-	case ComplexRoast:
-		sampleSize = 120
-	case BrutalRoast:
-		sampleSize = 150
-	default:
-		sampleSize = 80
-	}
+	completion, err := llm.Call(ctx, req.SystemPrompt+req.Prompt, options...)
 
-	// Cap sample size to actual command count
-	if sampleSize > len(commands) {
-		sampleSize = len(commands)
+	if err != nil {
+		return "", err
 	}
 
-	// Take commands from three key areas:
-
-	// 1. Most recent commands (40% of sample)
-	recentCount := sampleSize * 4 / 10
-	startIdx := len(commands) - recentCount
-	if startIdx < 0 {
-		startIdx = 0
-	}
-	for i := startIdx; i < len(commands); i++ {
-		sampledCommands = append(sampledCommands, commands[i])
-	}
+	return completion, nil
+}
 
-	// 2. Middle commands (30% of sample)
-	midCount := sampleSize * 3 / 10
-	midPoint := len(commands) / 2
-	startIdx = midPoint - midCount/2
-	endIdx := startIdx + midCount
-	if startIdx < 0 {
-		startIdx = 0
-	}
-	if endIdx > len(commands) {
-		endIdx = len(commands)
+// canStream reports whether the selected provider can send the roast as it is
+// generated. Raw HTTP endpoints only ever return the whole response.
+func canStream(cfg config.Config) bool {
+	if !cfg.AI.Stream {
+		return false
 	}
+	return cfg.AI.Provider != "custom" || !strings.EqualFold(cfg.AI.Custom.Mode, customModeHTTP)
+}
 
-	for i := startIdx; i < endIdx; i++ {
-		sampledCommands = append(sampledCommands, commands[i])
-	}
+// generateWithSystemMessage sends the system prompt, any earlier turns of the
+// conversation and the roast prompt as separate messages
+func generateWithSystemMessage(ctx context.Context, llm llms.Model, req Request, options ...llms.CallOption) (string, error) {
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, req.SystemPrompt)}
+	messages = append(messages, req.History...)
+	messages = append(messages, llms.TextParts(llms.ChatMessageTypeHuman, req.Prompt))
 
-	// 3. Earliest commands (30% of sample)
-	earlyCount := sampleSize * 3 / 10
-	if earlyCount > len(commands) {
-		earlyCount = len(commands)
+	resp, err := llm.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return "", err
 	}
 
-	for i := 0; i < earlyCount; i++ {
-		sampledCommands = append(sampledCommands, commands[i])
+	if len(resp.Choices) == 0 {
+		return "", errors.New("empty response from AI provider")
 	}
 
-	return sampledCommands
+	return resp.Choices[0].Content, nil
 }
@@ -0,0 +1,73 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+func TestAnthropicMessagesAPI(t *testing.T) {
+	var got struct {
+		Model    string `json:"model"`
+		System   string `json:"system"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content any    `json:"content"`
+		} `json:"messages"`
+		MaxTokens int `json:"max_tokens"`
+	}
+	var path, apiKey string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, apiKey = r.URL.Path, r.Header.Get("x-api-key")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-test",
+			"content": [{"type": "text", "text": "Your git log reads like a ransom note."}],
+			"stop_reason": "end_turn", "usage": {"input_tokens": 10, "output_tokens": 9}
+		}`))
+	}))
+	defer server.Close()
+
+	var cfg config.Config
+	cfg.AI.Provider = "anthropic"
+	cfg.AI.Anthropic = config.AIProviderConfig{APIKey: "test-key", BaseURL: server.URL, Model: "claude-test"}
+
+	req := Request{SystemPrompt: "You are a roast comedian.", Prompt: "Roast these commands.", MaxTokens: 200, Temperature: 0.7}
+	text, err := generateAIRoast(context.Background(), cfg, req, nil)
+	if err != nil {
+		t.Fatalf("generateAIRoast: %v", err)
+	}
+
+	if want := "Your git log reads like a ransom note."; text != want {
+		t.Errorf("roast = %q, want %q", text, want)
+	}
+	if path != "/v1/messages" {
+		t.Errorf("request went to %q, want /v1/messages", path)
+	}
+	if apiKey != "test-key" {
+		t.Errorf("x-api-key = %q, want test-key", apiKey)
+	}
+	if got.Model != "claude-test" {
+		t.Errorf("model = %q, want claude-test", got.Model)
+	}
+	if got.System != req.SystemPrompt {
+		t.Errorf("system = %q, want the system prompt %q", got.System, req.SystemPrompt)
+	}
+	for _, msg := range got.Messages {
+		if msg.Role == "system" {
+			t.Errorf("system prompt was also sent as a message")
+		}
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Role != "user" {
+		t.Errorf("messages = %+v, want just the user prompt", got.Messages)
+	}
+}
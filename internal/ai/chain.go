@@ -0,0 +1,114 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// Backoff between retries starts at backoffBase and doubles up to backoffMax
+const (
+	backoffBase = 500 * time.Millisecond
+	backoffMax  = 10 * time.Second
+)
+
+// defaultTimeout bounds a single provider call when no timeout is configured
+const defaultTimeout = 60 * time.Second
+
+// providerChain returns the providers to try in order, falling back to the
+// single configured provider
+func providerChain(cfg config.Config) []string {
+	if len(cfg.AI.Providers) > 0 {
+		return cfg.AI.Providers
+	}
+	if cfg.AI.Provider != "" {
+		return []string{cfg.AI.Provider}
+	}
+	return []string{"local"}
+}
+
+// withProvider returns a copy of the config with the given provider selected
+func withProvider(cfg config.Config, provider string) config.Config {
+	cfg.AI.Provider = provider
+	return cfg
+}
+
+// retryPolicy returns how many times to retry the selected provider and how
+// long each call may take, preferring the provider's own settings
+func retryPolicy(cfg config.Config) (int, time.Duration) {
+	settings := providerConfig(cfg, cfg.AI.Provider)
+
+	retries := cfg.AI.Retries
+	if settings.Retries != nil {
+		retries = *settings.Retries
+	}
+
+	timeout := cfg.AI.Timeout
+	if settings.Timeout > 0 {
+		timeout = settings.Timeout
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return retries, timeout
+}
+
+// generateWithRetries calls the selected provider, retrying rate limits and
+// server errors with exponential backoff
+func generateWithRetries(ctx context.Context, cfg config.Config, req Request, stream StreamFunc) (string, error) {
+	retries, timeout := retryPolicy(cfg)
+
+	var streamed bool
+	var track StreamFunc
+	if stream != nil {
+		track = func(text string) {
+			streamed = true
+			stream(text)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		text, err := generateAIRoast(callCtx, cfg, req, track)
+		cancel()
+		if err == nil {
+			return text, nil
+		}
+
+		// Whatever streamed before the failure is incomplete
+		if streamed {
+			stream("")
+			streamed = false
+		}
+
+		err = classifyError(cfg.AI.Provider, err)
+		if attempt >= retries || !retryable(err) || ctx.Err() != nil {
+			return "", err
+		}
+
+		select {
+		case <-time.After(backoff(attempt)):
+		case <-ctx.Done():
+			return "", err
+		}
+	}
+}
+
+// retryable reports whether a failure is likely to go away if we try again
+func retryable(err error) bool {
+	return errors.Is(err, ErrRateLimit) || errors.Is(err, ErrUnavailable)
+}
+
+// backoff returns how long to wait before the given retry: exponential, capped,
+// with jitter so many clients don't retry in lockstep
+func backoff(attempt int) time.Duration {
+	delay := backoffBase << attempt
+	if delay > backoffMax || delay <= 0 {
+		delay = backoffMax
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
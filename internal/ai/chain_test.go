@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	zero, three := 0, 3
+
+	tests := []struct {
+		name    string
+		retries *int
+		want    int
+	}{
+		{name: "unset uses ai.retries", retries: nil, want: 2},
+		{name: "provider override", retries: &three, want: 3},
+		{name: "provider turns retries off", retries: &zero, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config.Config
+			cfg.AI.Provider = "openai"
+			cfg.AI.Retries = 2
+			cfg.AI.OpenAI.Retries = tt.retries
+
+			if got, _ := retryPolicy(cfg); got != tt.want {
+				t.Errorf("retries = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunChainSkipsProviderThatCantBuild(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-test",
+			"content": [{"type": "text", "text": "Nice force push."}],
+			"stop_reason": "end_turn", "usage": {"input_tokens": 10, "output_tokens": 3}
+		}`))
+	}))
+	defer server.Close()
+
+	var cfg config.Config
+	cfg.AI.Providers = []string{"openai", "anthropic", "local"}
+	cfg.AI.Provider = "openai"
+	cfg.AI.NoFallback = true
+	cfg.AI.Anthropic = config.AIProviderConfig{APIKey: "test-key", BaseURL: server.URL, Model: "claude-test"}
+
+	var built []string
+	roast, _, err := runChain(context.Background(), cfg, analysis.CommandPattern{}, nil, SimpleRoast, nil, nil, func(providerCfg config.Config) (Request, error) {
+		built = append(built, providerCfg.AI.Provider)
+		if providerCfg.AI.Provider == "openai" {
+			return Request{}, errors.New("prompt template is broken")
+		}
+		return Request{Prompt: "Roast these commands.", MaxTokens: 100}, nil
+	})
+	if err != nil {
+		t.Fatalf("runChain: %v", err)
+	}
+
+	if want := []string{"openai", "anthropic"}; !reflect.DeepEqual(built, want) {
+		t.Errorf("built requests for %q, want %q", built, want)
+	}
+	if roast.Provider != "anthropic" || roast.Text != "Nice force push." {
+		t.Errorf("roast = %+v, want the anthropic one", roast)
+	}
+	if roast.Fallback == nil || !strings.Contains(roast.Fallback.Error(), "openai: prompt template is broken") {
+		t.Errorf("Fallback = %v, want the openai build error", roast.Fallback)
+	}
+}
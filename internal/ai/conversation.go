@@ -0,0 +1,82 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ErrNoConversation is returned when the user replies before any AI roast
+var ErrNoConversation = errors.New("nothing to reply to yet; the built-in roasts can't talk back")
+
+// Conversation is a roast session that remembers what was said, so later
+// roasts build on earlier ones and the user can talk back
+type Conversation struct {
+	cfg     config.Config
+	history []llms.MessageContent
+	memory  *sessionMemory
+}
+
+// NewConversation starts an empty conversation
+func NewConversation(cfg config.Config) *Conversation {
+	return &Conversation{cfg: cfg, memory: newSessionMemory()}
+}
+
+// Roast generates the first roast of the conversation, or another one that
+// finds new material instead of repeating the earlier ones
+func (c *Conversation) Roast(ctx context.Context, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel, stream StreamFunc) (Roast, error) {
+	name := complexity.String()
+	if len(c.history) > 0 {
+		name = "again"
+	}
+
+	return c.send(ctx, patterns, commands, complexity, stream, name, "")
+}
+
+// Reply answers something the user said, such as a comeback, a question about
+// an earlier roast or a request to roast something in particular
+func (c *Conversation) Reply(ctx context.Context, message string, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel, stream StreamFunc) (Roast, error) {
+	if len(c.history) == 0 {
+		return Roast{}, ErrNoConversation
+	}
+
+	return c.send(ctx, patterns, commands, complexity, stream, "reply", strings.TrimSpace(message))
+}
+
+// send runs the next turn through the provider chain and remembers the answer.
+// Built-in roasts aren't added to the conversation, since no model said them,
+// but they're still kept out of later roasts.
+func (c *Conversation) send(ctx context.Context, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel,
+	stream StreamFunc, name, message string) (Roast, error) {
+	t := turn{template: name, message: message, history: c.history, covered: c.memory.covered()}
+	roast, req, err := runChain(ctx, c.cfg, patterns, commands, complexity, stream, c.memory, func(cfg config.Config) (Request, error) {
+		return buildRequest(cfg, patterns, commands, complexity, t)
+	})
+	if err != nil {
+		return roast, err
+	}
+
+	// A new roast that rewords an earlier one is asked for again. Replies are
+	// left alone, since explaining a joke means repeating it.
+	if roast.Provider != "local" && name != "reply" {
+		roast.Text, err = c.memory.avoidRepeats(ctx, withProvider(c.cfg, roast.Provider), req, roast.Text, stream)
+		if err != nil {
+			return Roast{}, err
+		}
+	}
+
+	c.memory.add(roast.Text, patterns)
+	if roast.Provider == "local" {
+		return roast, nil
+	}
+
+	c.history = append(c.history,
+		llms.TextParts(llms.ChatMessageTypeHuman, req.Prompt),
+		llms.TextParts(llms.ChatMessageTypeAI, roast.Text),
+	)
+	return roast, nil
+}
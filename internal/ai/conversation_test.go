@@ -0,0 +1,165 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// chatMessage is a message as sent to an OpenAI-compatible endpoint
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatServer is an OpenAI-compatible endpoint that answers with the given
+// replies in turn and records the messages of every request
+type chatServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	replies  []string
+	requests [][]chatMessage
+}
+
+// newChatServer starts a chat server that's closed when the test ends
+func newChatServer(t *testing.T, replies ...string) *chatServer {
+	t.Helper()
+
+	s := &chatServer{replies: replies}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []chatMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, body.Messages)
+		reply := s.replies[min(len(s.requests), len(s.replies))-1]
+		s.mu.Unlock()
+
+		content, _ := json.Marshal(reply)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{
+			"id": "chatcmpl-1", "object": "chat.completion", "created": 1, "model": "local-model",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": %s}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 6, "total_tokens": 16}
+		}`, content)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// config selects the custom provider in OpenAI mode, pointed at the server
+func (s *chatServer) config() config.Config {
+	cfg := customConfig(config.CustomProviderConfig{
+		AIProviderConfig: config.AIProviderConfig{BaseURL: s.URL + "/v1", Model: "local-model"},
+	})
+	cfg.AI.NoFallback = true
+	cfg.AI.Seed = 1
+	return cfg
+}
+
+// roles returns the role of each message, so turns can be compared at a glance
+func roles(messages []chatMessage) string {
+	var names []string
+	for _, m := range messages {
+		names = append(names, m.Role)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestConversationKeepsState(t *testing.T) {
+	userPrompts(t)
+	server := newChatServer(t,
+		"You commit like you're defusing a bomb.",
+		"And gti? Your fingers are in witness protection.",
+		"Vim, btw, explains why you never exit anything.",
+	)
+	conv := NewConversation(server.config())
+	commands := []string{"git commit -m wip", "gti status", "vim main.go"}
+	ctx := context.Background()
+
+	if _, err := conv.Reply(ctx, "I use vim, btw", promptPatterns(), commands, SimpleRoast, nil); !errors.Is(err, ErrNoConversation) {
+		t.Fatalf("Reply before any roast: err = %v, want ErrNoConversation", err)
+	}
+	if len(server.requests) != 0 {
+		t.Fatalf("Reply before any roast sent %d requests", len(server.requests))
+	}
+
+	first, err := conv.Roast(ctx, promptPatterns(), commands, SimpleRoast, nil)
+	if err != nil {
+		t.Fatalf("first roast: %v", err)
+	}
+	second, err := conv.Roast(ctx, promptPatterns(), commands, SimpleRoast, nil)
+	if err != nil {
+		t.Fatalf("second roast: %v", err)
+	}
+	reply, err := conv.Reply(ctx, "  I use vim, btw\n", promptPatterns(), commands, SimpleRoast, nil)
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+
+	for i, roast := range []Roast{first, second, reply} {
+		if want := server.replies[i]; roast.Text != want || roast.Provider != "custom" {
+			t.Errorf("turn %d = %+v, want %q from custom", i+1, roast, want)
+		}
+	}
+
+	if len(server.requests) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(server.requests))
+	}
+	wantRoles := []string{"system,user", "system,user,assistant,user", "system,user,assistant,user,assistant,user"}
+	for i, messages := range server.requests {
+		if got := roles(messages); got != wantRoles[i] {
+			t.Errorf("request %d roles = %s, want %s", i+1, got, wantRoles[i])
+		}
+	}
+
+	// Each turn carries the earlier ones word for word
+	again, replied := server.requests[1], server.requests[2]
+	if again[2].Content != first.Text || replied[4].Content != second.Text {
+		t.Errorf("earlier roasts weren't sent back as assistant messages: %+v", replied)
+	}
+	if again[1].Content != server.requests[0][1].Content || replied[3].Content != again[3].Content {
+		t.Error("earlier prompts weren't sent back unchanged")
+	}
+
+	// Later roasts ask for new material, replies pass on what the user said
+	if !strings.HasPrefix(again[3].Content, "Roast me again.") {
+		t.Errorf("second prompt doesn't use the again template:\n%s", again[3].Content)
+	}
+	if !strings.HasPrefix(replied[5].Content, "I use vim, btw\n") {
+		t.Errorf("reply prompt doesn't start with the trimmed message:\n%s", replied[5].Content)
+	}
+}
+
+func TestConversationLocalRoastsCantTalkBack(t *testing.T) {
+	userPrompts(t)
+	var cfg config.Config
+	cfg.AI.Provider = "local"
+	conv := NewConversation(cfg)
+
+	roast, err := conv.Roast(context.Background(), promptPatterns(), []string{"ls", "gti status"}, SimpleRoast, nil)
+	if err != nil {
+		t.Fatalf("Roast: %v", err)
+	}
+	if roast.Provider != "local" || roast.Text == "" {
+		t.Fatalf("roast = %+v, want a built-in roast", roast)
+	}
+
+	// Built-in roasts aren't part of the conversation, so there's nothing to reply to
+	if _, err := conv.Reply(context.Background(), "rude", promptPatterns(), nil, SimpleRoast, nil); !errors.Is(err, ErrNoConversation) {
+		t.Errorf("Reply after a local roast: err = %v, want ErrNoConversation", err)
+	}
+}
@@ -0,0 +1,242 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// Modes supported by the custom provider
+const (
+	customModeOpenAI = "openai"
+	customModeHTTP   = "http"
+)
+
+// customTimeout bounds a single request to a custom endpoint
+const customTimeout = 2 * time.Minute
+
+// initCustom initializes a client for a user-supplied endpoint, either an
+// OpenAI-compatible API (LM Studio, vLLM, llama.cpp server, LocalAI) or a
+// raw HTTP endpoint described by a body template and a response path
+func initCustom(cfg config.Config) (llms.Model, error) {
+	custom := cfg.AI.Custom
+	if custom.BaseURL == "" {
+		return nil, errors.New("custom provider base URL not configured")
+	}
+
+	switch strings.ToLower(custom.Mode) {
+	case "", customModeOpenAI:
+		return initOpenAICompatible(custom)
+	case customModeHTTP:
+		return newHTTPModel(custom)
+	}
+	return nil, fmt.Errorf("unknown custom provider mode %q (expected %q or %q)", custom.Mode, customModeOpenAI, customModeHTTP)
+}
+
+// initOpenAICompatible initializes an OpenAI client pointed at a compatible server
+func initOpenAICompatible(custom config.CustomProviderConfig) (llms.Model, error) {
+	// Local servers usually don't check the key, but the client insists on one
+	token := custom.APIKey
+	if token == "" {
+		token = "none"
+	}
+
+	options := []openai.Option{
+		openai.WithToken(token),
+		openai.WithBaseURL(custom.BaseURL),
+	}
+
+	if custom.Model != "" {
+		options = append(options, openai.WithModel(custom.Model))
+	}
+
+	return openai.New(options...)
+}
+
+// httpModel sends the roast request to an arbitrary HTTP endpoint. The request
+// body is rendered from a template and the roast is read from the JSON response.
+type httpModel struct {
+	url          string
+	apiKey       string
+	model        string
+	headers      map[string]string
+	body         *template.Template
+	responsePath string
+	client       *http.Client
+}
+
+// httpTemplateData is what a custom body template can reference
+type httpTemplateData struct {
+	Model        string
+	SystemPrompt string
+	Prompt       string
+	MaxTokens    int
+	Temperature  float64
+}
+
+// templateFuncs are available in custom body templates. "json" encodes a value
+// as JSON, so prompts can be embedded with {{json .Prompt}} without breaking quoting.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// newHTTPModel validates the custom config and parses its body template
+func newHTTPModel(custom config.CustomProviderConfig) (*httpModel, error) {
+	if custom.BodyTemplate == "" {
+		return nil, errors.New("custom provider in http mode needs a body_template")
+	}
+	if custom.ResponsePath == "" {
+		return nil, errors.New("custom provider in http mode needs a response_path")
+	}
+	if _, err := parseJSONPath(custom.ResponsePath); err != nil {
+		return nil, fmt.Errorf("invalid custom response_path: %v", err)
+	}
+
+	body, err := template.New("body").Funcs(templateFuncs).Option("missingkey=error").Parse(custom.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom body_template: %v", err)
+	}
+
+	return &httpModel{
+		url:          custom.BaseURL,
+		apiKey:       custom.APIKey,
+		model:        custom.Model,
+		headers:      custom.Headers,
+		body:         body,
+		responsePath: custom.ResponsePath,
+		client:       &http.Client{Timeout: customTimeout},
+	}, nil
+}
+
+// GenerateContent renders the system and human messages into the body template and posts it
+func (m *httpModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	data := httpTemplateData{
+		Model:       m.model,
+		MaxTokens:   opts.MaxTokens,
+		Temperature: opts.Temperature,
+	}
+	var turns []llms.MessageContent
+	for _, msg := range messages {
+		if msg.Role == llms.ChatMessageTypeSystem {
+			data.SystemPrompt += messageText(msg)
+		} else {
+			turns = append(turns, msg)
+		}
+	}
+	data.Prompt = transcript(turns)
+
+	content, err := m.send(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: content}}}, nil
+}
+
+// Call sends a single prompt with no system message
+func (m *httpModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// send posts the rendered body and extracts the roast from the response
+func (m *httpModel) send(ctx context.Context, data httpTemplateData) (string, error) {
+	var body bytes.Buffer
+	if err := m.body.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("failed to render custom body_template: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, &body)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+	// Configured headers win, so gateways with their own auth scheme can replace the bearer token
+	for name, value := range m.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("custom provider returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	var decoded any
+	if err := json.Unmarshal(respBody, &decoded); err != nil {
+		return "", fmt.Errorf("custom provider returned invalid JSON: %v", err)
+	}
+
+	value, err := evalJSONPath(decoded, m.responsePath)
+	if err != nil {
+		return "", fmt.Errorf("custom provider response: %v", err)
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("custom provider response: %s is not a string", m.responsePath)
+	}
+	return text, nil
+}
+
+// transcript flattens a conversation into one prompt for endpoints that only
+// take one, labelling who said what when there is more than a single message
+func transcript(turns []llms.MessageContent) string {
+	if len(turns) == 1 {
+		return messageText(turns[0])
+	}
+
+	var text strings.Builder
+	for _, msg := range turns {
+		if msg.Role == llms.ChatMessageTypeAI {
+			text.WriteString("You: ")
+		} else {
+			text.WriteString("User: ")
+		}
+		text.WriteString(messageText(msg))
+		text.WriteString("\n\n")
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// messageText joins the text parts of a message
+func messageText(msg llms.MessageContent) string {
+	var text strings.Builder
+	for _, part := range msg.Parts {
+		if t, ok := part.(llms.TextContent); ok {
+			text.WriteString(t.Text)
+		}
+	}
+	return text.String()
+}
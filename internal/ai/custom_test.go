@@ -0,0 +1,219 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// customConfig selects the custom provider with the given settings
+func customConfig(custom config.CustomProviderConfig) config.Config {
+	var cfg config.Config
+	cfg.AI.Provider = "custom"
+	cfg.AI.Custom = custom
+	return cfg
+}
+
+func TestCustomHTTPMode(t *testing.T) {
+	var body map[string]any
+	var path string
+	var header http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, header = r.URL.Path, r.Header
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"output": [{"text": "draft"}, {"text": "You alias ls to ls."}]}`))
+	}))
+	defer server.Close()
+
+	cfg := customConfig(config.CustomProviderConfig{
+		AIProviderConfig: config.AIProviderConfig{APIKey: "test-key", BaseURL: server.URL + "/generate", Model: "roaster-1"},
+		Mode:             "http",
+		BodyTemplate:     `{"model": {{json .Model}}, "system": {{json .SystemPrompt}}, "input": {{json .Prompt}}, "max": {{.MaxTokens}}}`,
+		ResponsePath:     "$.output[-1].text",
+		Headers:          map[string]string{"X-Team": "roasters"},
+	})
+
+	// Quotes and newlines in the prompt must survive the template
+	req := Request{SystemPrompt: "Be \"savage\".", Prompt: "Roast:\nrm -rf node_modules", MaxTokens: 150}
+	text, err := generateAIRoast(context.Background(), cfg, req, nil)
+	if err != nil {
+		t.Fatalf("generateAIRoast: %v", err)
+	}
+
+	if want := "You alias ls to ls."; text != want {
+		t.Errorf("roast = %q, want %q", text, want)
+	}
+	if path != "/generate" {
+		t.Errorf("request went to %q, want /generate", path)
+	}
+	want := map[string]any{"model": "roaster-1", "system": req.SystemPrompt, "input": req.Prompt, "max": 150.0}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("body[%q] = %#v, want %#v", key, body[key], value)
+		}
+	}
+	if got := header.Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("Authorization = %q, want the bearer token", got)
+	}
+	if got := header.Get("X-Team"); got != "roasters" {
+		t.Errorf("X-Team = %q, want the configured header", got)
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestCustomHTTPModeHeadersReplaceBearer(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"text": "ok"}`))
+	}))
+	defer server.Close()
+
+	cfg := customConfig(config.CustomProviderConfig{
+		AIProviderConfig: config.AIProviderConfig{APIKey: "test-key", BaseURL: server.URL},
+		Mode:             "http",
+		BodyTemplate:     `{"prompt": {{json .Prompt}}}`,
+		ResponsePath:     "text",
+		Headers:          map[string]string{"Authorization": "Token gateway-key"},
+	})
+
+	if _, err := generateAIRoast(context.Background(), cfg, Request{Prompt: "hi"}, nil); err != nil {
+		t.Fatalf("generateAIRoast: %v", err)
+	}
+	if auth != "Token gateway-key" {
+		t.Errorf("Authorization = %q, want the configured header", auth)
+	}
+}
+
+func TestCustomHTTPModeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		path     string
+		want     string // Part of the error message expected
+	}{
+		{name: "non-2xx status", status: http.StatusServiceUnavailable, response: "model is loading", path: "$.text", want: "503 Service Unavailable: model is loading"},
+		{name: "missing field", status: http.StatusOK, response: `{"result": "hi"}`, path: "$.text", want: `no field "text"`},
+		{name: "index out of range", status: http.StatusOK, response: `{"choices": []}`, path: "$.choices[0].text", want: "index 0 out of range"},
+		{name: "not a string", status: http.StatusOK, response: `{"text": 42}`, path: "$.text", want: "$.text is not a string"},
+		{name: "not JSON", status: http.StatusOK, response: "hello", path: "$.text", want: "invalid JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			cfg := customConfig(config.CustomProviderConfig{
+				AIProviderConfig: config.AIProviderConfig{BaseURL: server.URL},
+				Mode:             "http",
+				BodyTemplate:     `{"prompt": {{json .Prompt}}}`,
+				ResponsePath:     tt.path,
+			})
+
+			_, err := generateAIRoast(context.Background(), cfg, Request{Prompt: "hi"}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHTTPModelValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		custom config.CustomProviderConfig
+		want   string
+	}{
+		{name: "no body template", custom: config.CustomProviderConfig{ResponsePath: "$.text"}, want: "needs a body_template"},
+		{name: "no response path", custom: config.CustomProviderConfig{BodyTemplate: "{}"}, want: "needs a response_path"},
+		{name: "bad response path", custom: config.CustomProviderConfig{BodyTemplate: "{}", ResponsePath: "$.choices[0"}, want: "invalid custom response_path"},
+		{name: "bad template", custom: config.CustomProviderConfig{BodyTemplate: "{{.Prompt", ResponsePath: "$.text"}, want: "invalid custom body_template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newHTTPModel(tt.custom); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomOpenAIMode(t *testing.T) {
+	var path, auth string
+	var got struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role string `json:"role"`
+		} `json:"messages"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "chatcmpl-1", "object": "chat.completion", "created": 1, "model": "local-model",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": "Even your typos have typos."}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 6, "total_tokens": 16}
+		}`))
+	}))
+	defer server.Close()
+
+	// No API key, as local servers usually don't want one
+	cfg := customConfig(config.CustomProviderConfig{
+		AIProviderConfig: config.AIProviderConfig{BaseURL: server.URL + "/v1", Model: "local-model"},
+	})
+
+	text, err := generateAIRoast(context.Background(), cfg, Request{SystemPrompt: "Be mean.", Prompt: "Roast these commands.", MaxTokens: 100}, nil)
+	if err != nil {
+		t.Fatalf("generateAIRoast: %v", err)
+	}
+
+	if want := "Even your typos have typos."; text != want {
+		t.Errorf("roast = %q, want %q", text, want)
+	}
+	if path != "/v1/chat/completions" {
+		t.Errorf("request went to %q, want /v1/chat/completions", path)
+	}
+	if auth != "Bearer none" {
+		t.Errorf("Authorization = %q, want the placeholder token", auth)
+	}
+	if got.Model != "local-model" {
+		t.Errorf("model = %q, want local-model", got.Model)
+	}
+	if len(got.Messages) != 2 || got.Messages[0].Role != "system" || got.Messages[1].Role != "user" {
+		t.Errorf("messages = %+v, want a system and a user message", got.Messages)
+	}
+}
+
+func TestCustomUnknownMode(t *testing.T) {
+	cfg := customConfig(config.CustomProviderConfig{
+		AIProviderConfig: config.AIProviderConfig{BaseURL: "http://localhost:1"},
+		Mode:             "grpc",
+	})
+	if _, err := initCustom(cfg); err == nil || !strings.Contains(err.Error(), `unknown custom provider mode "grpc"`) {
+		t.Errorf("err = %v, want an unknown mode error", err)
+	}
+}
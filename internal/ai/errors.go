@@ -0,0 +1,154 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of provider failure, matched with errors.Is
+var (
+	ErrAuth          = errors.New("authentication failed")
+	ErrRateLimit     = errors.New("rate limited")
+	ErrModelNotFound = errors.New("model not found")
+	ErrNetwork       = errors.New("network error")
+	ErrContextLength = errors.New("context length exceeded")
+	ErrUnavailable   = errors.New("provider unavailable")
+	ErrProvider      = errors.New("provider error")
+)
+
+// errorKinds are the kinds an error can already carry, most specific first
+var errorKinds = []error{ErrAuth, ErrRateLimit, ErrModelNotFound, ErrNetwork, ErrContextLength, ErrUnavailable, ErrProvider}
+
+// ProviderError is a failure talking to an AI provider, tagged with what kind of failure it was
+type ProviderError struct {
+	Provider string
+	Kind     error
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s: %v: %v", e.Provider, e.Kind, e.Err)
+}
+
+// Unwrap lets errors.Is match both the kind and the underlying error
+func (e *ProviderError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// Hint suggests how to fix the failure
+func (e *ProviderError) Hint() string {
+	switch e.Kind {
+	case ErrAuth:
+		return "Check the API key for " + e.Provider + " with 'roastme config'"
+	case ErrRateLimit:
+		return "You've hit the provider's rate limit or quota; wait a bit or switch providers"
+	case ErrModelNotFound:
+		return "Check the model name for " + e.Provider + " with 'roastme config'"
+	case ErrNetwork:
+		return "Check your connection and the provider's base URL"
+	case ErrContextLength:
+		return "Try a lower --limit or a simpler --complexity"
+	case ErrUnavailable:
+		return "The provider is having trouble; try again later"
+	}
+	return ""
+}
+
+// ErrorHint returns the fix suggestion for a provider error, or an empty string
+func ErrorHint(err error) string {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Hint()
+	}
+	return ""
+}
+
+// statusCodeRe finds the HTTP status code in provider error messages, such as
+// "API returned unexpected status code: 429" or "googleapi: Error 403"
+var statusCodeRe = regexp.MustCompile(`(?i)(?:status(?: code)?:?|returned|error)\s+(\d{3})\b`)
+
+// classifyError wraps an error from a provider in a ProviderError of the right kind.
+// The client libraries don't share error types, so this goes by status code and message.
+func classifyError(provider string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return err
+	}
+
+	return &ProviderError{Provider: provider, Kind: errorKind(err), Err: err}
+}
+
+// errorKind works out what kind of failure an error describes
+func errorKind(err error) error {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+
+	// Checked first because these usually come back as a plain 400
+	if containsAny(msg, "context length", "context_length", "maximum context", "context window",
+		"too many tokens", "prompt is too long", "token limit") {
+		return ErrContextLength
+	}
+
+	if m := statusCodeRe.FindStringSubmatch(msg); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		switch {
+		case code == 401 || code == 403:
+			return ErrAuth
+		case code == 429:
+			return ErrRateLimit
+		case code == 404:
+			return ErrModelNotFound
+		case code == 413:
+			return ErrContextLength
+		case code >= 500:
+			return ErrUnavailable
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		containsAny(msg, "connection refused", "no such host", "dial tcp", "i/o timeout", "connection reset") {
+		return ErrNetwork
+	}
+
+	switch {
+	case containsAny(msg, "api key", "api_key", "x-api-key", "unauthorized", "unauthenticated",
+		"authentication", "permission denied", "permission_denied"):
+		return ErrAuth
+	case containsAny(msg, "rate limit", "rate_limit", "quota", "resource_exhausted", "resourceexhausted",
+		"too many requests"):
+		return ErrRateLimit
+	case containsAny(msg, "model_not_found", "model not found", "unknown model", "not_found_error",
+		"does not exist", "is not found"):
+		return ErrModelNotFound
+	case containsAny(msg, "overloaded", "unavailable", "internal server error", "bad gateway"):
+		return ErrUnavailable
+	}
+
+	return ErrProvider
+}
+
+// containsAny reports whether s contains any of the substrings
+func containsAny(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{name: "401 status", err: errors.New("API returned unexpected status code: 401"), kind: ErrAuth},
+		{name: "403 googleapi", err: errors.New("googleapi: Error 403: forbidden"), kind: ErrAuth},
+		{name: "invalid api key", err: errors.New("Incorrect API key provided"), kind: ErrAuth},
+		{name: "429 status", err: errors.New("API returned unexpected status code: 429"), kind: ErrRateLimit},
+		{name: "quota message", err: errors.New("RESOURCE_EXHAUSTED: quota exceeded"), kind: ErrRateLimit},
+		{name: "404 status", err: errors.New("status code: 404"), kind: ErrModelNotFound},
+		{name: "model not found message", err: errors.New("model_not_found: gpt-9 does not exist"), kind: ErrModelNotFound},
+		{name: "context length message", err: errors.New("status code: 400: This model's maximum context length is 8192 tokens"), kind: ErrContextLength},
+		{name: "413 status", err: errors.New("returned 413"), kind: ErrContextLength},
+		{name: "500 status", err: errors.New("API returned unexpected status code: 500"), kind: ErrUnavailable},
+		{name: "overloaded message", err: errors.New("overloaded_error: Overloaded"), kind: ErrUnavailable},
+		{name: "connection refused", err: errors.New("dial tcp 127.0.0.1:11434: connect: connection refused"), kind: ErrNetwork},
+		{name: "net.Error", err: &net.DNSError{Err: "no such host", Name: "api.example.com"}, kind: ErrNetwork},
+		{name: "deadline exceeded", err: fmt.Errorf("request failed: %w", context.DeadlineExceeded), kind: ErrNetwork},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, kind: ErrNetwork},
+		{name: "already tagged kind", err: fmt.Errorf("ollama: %w", ErrModelNotFound), kind: ErrModelNotFound},
+		{name: "anything else", err: errors.New("something odd happened"), kind: ErrProvider},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError("openai", tt.err)
+
+			var providerErr *ProviderError
+			if !errors.As(err, &providerErr) {
+				t.Fatalf("classifyError returned %T, want a *ProviderError", err)
+			}
+			if providerErr.Kind != tt.kind {
+				t.Errorf("kind = %v, want %v", providerErr.Kind, tt.kind)
+			}
+			if providerErr.Provider != "openai" {
+				t.Errorf("provider = %q, want openai", providerErr.Provider)
+			}
+			if !errors.Is(err, tt.kind) || !errors.Is(err, tt.err) {
+				t.Errorf("errors.Is doesn't match both the kind and the original error")
+			}
+		})
+	}
+}
+
+func TestClassifyErrorKeepsProviderErrors(t *testing.T) {
+	if classifyError("openai", nil) != nil {
+		t.Error("classifyError(nil) should be nil")
+	}
+
+	original := &ProviderError{Provider: "ollama", Kind: ErrModelNotFound, Err: errors.New("llama9 isn't pulled")}
+	if err := classifyError("openai", fmt.Errorf("fallback: %w", original)); !errors.Is(err, original) {
+		t.Errorf("classifyError rewrapped an existing ProviderError: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package ai
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathStep is a single object key or array index in a JSONPath
+type pathStep struct {
+	key   string
+	index int
+	isKey bool
+}
+
+// parseJSONPath parses the subset of JSONPath needed to point at a field in a
+// response: a leading "$", dotted keys, quoted keys in brackets and array
+// indexes, e.g. $.choices[0].message.content or $['output'][-1].text
+func parseJSONPath(path string) ([]pathStep, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+
+	var steps []pathStep
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in path")
+			}
+			steps = append(steps, pathStep{key: path[:end], isKey: true})
+			path = path[end:]
+
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in path")
+			}
+			inner := strings.TrimSpace(path[1:end])
+			path = path[end+1:]
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, pathStep{key: inner[1 : len(inner)-1], isKey: true})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q in path", inner)
+			}
+			steps = append(steps, pathStep{index: index})
+
+		default:
+			// Allow a bare leading key, as in choices[0].text
+			if len(steps) > 0 {
+				return nil, fmt.Errorf("unexpected %q in path", path[0])
+			}
+			path = "." + path
+		}
+	}
+
+	return steps, nil
+}
+
+// evalJSONPath returns the value at path in a decoded JSON document
+func evalJSONPath(doc any, path string) (any, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := doc
+	for _, step := range steps {
+		if step.isKey {
+			obj, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: expected an object at %q", path, step.key)
+			}
+			if value, ok = obj[step.key]; !ok {
+				return nil, fmt.Errorf("%s: no field %q", path, step.key)
+			}
+			continue
+		}
+
+		arr, ok := value.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected an array at [%d]", path, step.index)
+		}
+		index := step.index
+		// Negative indexes count from the end
+		if index < 0 {
+			index += len(arr)
+		}
+		if index < 0 || index >= len(arr) {
+			return nil, fmt.Errorf("%s: index %d out of range", path, step.index)
+		}
+		value = arr[index]
+	}
+
+	return value, nil
+}
@@ -0,0 +1,60 @@
+package ai
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvalJSONPath(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(`{
+		"choices": [{"message": {"content": "first"}}, {"message": {"content": "last"}}],
+		"output": {"text": "hi", "odd.key": "dotted"},
+		"count": 2
+	}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		want    any
+		wantErr string
+	}{
+		{path: "$.choices[0].message.content", want: "first"},
+		{path: "choices[1].message.content", want: "last"},
+		{path: "$.choices[-1].message.content", want: "last"},
+		{path: "$['output'].text", want: "hi"},
+		{path: `$.output["odd.key"]`, want: "dotted"},
+		{path: " $.count ", want: 2.0},
+		{path: "$", want: doc},
+		{path: "$.missing", wantErr: `no field "missing"`},
+		{path: "$.choices[2]", wantErr: "index 2 out of range"},
+		{path: "$.choices[-3]", wantErr: "index -3 out of range"},
+		{path: "$.count.value", wantErr: `expected an object at "value"`},
+		{path: "$.output[0]", wantErr: "expected an array at [0]"},
+		{path: "$.choices[0", wantErr: "unclosed ["},
+		{path: "$.choices[x]", wantErr: `invalid index "x"`},
+		{path: "$..text", wantErr: "empty key"},
+		{path: "$.choices[0]text", wantErr: `unexpected 't'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := evalJSONPath(doc, tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evalJSONPath: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
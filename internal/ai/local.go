@@ -1,106 +1,531 @@
 package ai
 
 import (
+	"bytes"
+	"embed"
 	"fmt"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
 	"github.com/jasonlovesdoggo/roastme/internal/analysis"
-	"math/rand"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/jasonlovesdoggo/roastme/internal/shell"
+	"gopkg.in/yaml.v3"
+)
+
+// builtinRoasts holds the packs of local roast templates. Packs in the user's
+// packs directory are added to them.
+//
+//go:embed roasts
+var builtinRoasts embed.FS
+
+// boringRoast is used when no template has anything to say
+const boringRoast = "I can't even roast your command history - it's that boring. Try doing something interesting first!"
+
+// maxLocalFindings bounds how many repeated, failed or complex commands each
+// get a finding, so roasts stick to the worst offenders
+const maxLocalFindings = 3
+
+// minToolUses is how many times a tool has to be used before it gets roasted
+const minToolUses = 3
+
+// findingWeight is how much likelier a roast about an analysis finding is to be
+// picked than one about a tool
+const findingWeight = 3.0
+
+// maxRoastCommandLength is how much of a long command is quoted in a roast
+const maxRoastCommandLength = 60
+
+// toolTags maps the tags of tool roasts to the programs that count as the tool
+var toolTags = map[string][]string{
+	"git":     {"git", "gh", "tig", "lazygit"},
+	"docker":  {"docker", "docker-compose", "podman"},
+	"kubectl": {"kubectl", "k9s", "helm", "minikube", "kind"},
+	"npm":     {"npm", "npx", "yarn", "pnpm"},
+	"python":  {"python", "python3", "pip", "pip3", "pipenv", "poetry"},
+	"ssh":     {"ssh", "scp", "mosh"},
+	"editor":  {"vim", "vi", "nvim", "nano", "emacs", "code", "micro"},
+	"clear":   {"clear"},
+	"make":    {"make"},
+	"man":     {"man", "tldr"},
+	"history": {"history"},
+	"curl":    {"curl", "wget"},
+	"rm":      {"rm"},
+}
+
+// findingTags are the tags that come from the analysis rather than from tools.
+// Each kind of tool finding is a tag too.
+var findingTags = []string{
+	"generic", "repeated", "failed", "complex", "indecisive", "timewaster", "shells",
+	"beginner", "intermediate", "advanced", "sudo",
+}
+
+// roastPack is a file of local roast templates. Roasts are grouped by the tag
+// of the finding they're about, intros and outros by complexity.
+type roastPack struct {
+	Roasts map[string][]string `yaml:"roasts"`
+	Intros map[string][]string `yaml:"intros"`
+	Outros map[string][]string `yaml:"outros"`
+}
+
+// RoastData is what local roast templates are filled with. Which fields are set
+// depends on the tag of the finding the roast is about.
+type RoastData struct {
+	Command  string                  // The command or program the roast is about
+	Count    int                     // How many times it was run, or failed commands there were
+	Percent  int                     // Its share of the history, in percent
+	Example  string                  // Its most recent full command line
+	Waster   string                  // The time-wasting site, for timewaster roasts
+	Shells   string                  // The shells used, for shells roasts
+	Summary  string                  // What the user did, for tool habit roasts
+	Skill    string                  // beginner, intermediate or advanced
+	Total    int                     // How many commands were analyzed
+	Patterns analysis.CommandPattern // Everything the analysis found
+}
+
+// roastFinding is one thing a local roast can be about
+type roastFinding struct {
+	tag  string
+	data RoastData
+}
+
+// roastCorpus is every pack's templates, parsed
+type roastCorpus struct {
+	roasts map[string][]*template.Template
+	intros map[string][]*template.Template
+	outros map[string][]*template.Template
+}
+
+var (
+	corpusOnce sync.Once
+	corpus     *roastCorpus
+	corpusErr  error
 )
 
-// generateLocalRoast generates a roast without using an external AI service
-func generateLocalRoast(patterns analysis.CommandPattern, complexity ComplexityLevel) string {
-	var basicRoasts []string
+// packsDir returns the directory where users keep their own roast packs
+func packsDir() string {
+	dir, err := config.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "packs")
+}
+
+// loadCorpus parses the built-in packs and the user's, once. A broken user pack
+// is reported, but the packs that loaded are still returned.
+func loadCorpus() (*roastCorpus, error) {
+	corpusOnce.Do(func() {
+		corpus, corpusErr = parseCorpus()
+	})
+	return corpus, corpusErr
+}
+
+// parseCorpus reads the built-in packs, then any *.yaml, *.yml or *.json files
+// in the user's packs directory
+func parseCorpus() (*roastCorpus, error) {
+	c := &roastCorpus{
+		roasts: make(map[string][]*template.Template),
+		intros: make(map[string][]*template.Template),
+		outros: make(map[string][]*template.Template),
+	}
 
-	if len(patterns.RepeatedCommands) > 0 {
-		cmd := patterns.RepeatedCommands[0].Command
-		count := patterns.RepeatedCommands[0].Count
-		basicRoasts = append(basicRoasts, fmt.Sprintf("I see you've used '%s' %d times. Having memory issues or just really, really in love with that command?", cmd, count))
+	builtin, err := fs.Glob(builtinRoasts, "roasts/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range builtin {
+		content, err := builtinRoasts.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.addPack(file, content); err != nil {
+			return nil, err
+		}
 	}
 
-	if len(patterns.FailedCommands) > 0 {
-		basicRoasts = append(basicRoasts, fmt.Sprintf("Nice typos! Maybe typing lessons should be in your future before attempting '%s'.", patterns.FailedCommands[0]))
+	dir := packsDir()
+	if dir == "" {
+		return c, nil
 	}
 
-	if len(patterns.ComplexCommands) > 0 {
-		basicRoasts = append(basicRoasts, "Wow, those complex commands! Trying to impress an invisible audience or just afraid of using separate lines?")
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.json"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
 	}
+	sort.Strings(files)
 
-	if patterns.Indecisive {
-		basicRoasts = append(basicRoasts, "All those cd's and ls's... are you exploring your filesystem or just completely lost in there?")
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return c, err
+		}
+		if err := c.addPack(file, content); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// addPack parses a pack and adds its templates, or none of them if any is broken
+func (c *roastCorpus) addPack(source string, content []byte) error {
+	var pack roastPack
+	// JSON is also YAML, so one decoder reads both
+	if err := yaml.Unmarshal(content, &pack); err != nil {
+		return fmt.Errorf("roast pack %s: %v", source, err)
+	}
+
+	parse := func(section, kind string, groups map[string][]string, valid func(string) bool) (map[string][]*template.Template, error) {
+		parsed := make(map[string][]*template.Template)
+		for key, texts := range groups {
+			if !valid(key) {
+				return nil, fmt.Errorf("roast pack %s: %s: unknown %s %q", source, section, kind, key)
+			}
+			for i, text := range texts {
+				tmpl, err := template.New(key).Funcs(promptFuncs).Parse(text)
+				if err == nil {
+					// Catch references to fields that don't exist now, not mid-roast
+					err = tmpl.Execute(&bytes.Buffer{}, RoastData{})
+				}
+				if err != nil {
+					return nil, fmt.Errorf("roast pack %s: %s %s #%d: %v", source, section, key, i+1, err)
+				}
+				parsed[key] = append(parsed[key], tmpl)
+			}
+		}
+		return parsed, nil
+	}
+
+	complexity := func(key string) bool { return key == "complex" || key == "brutal" }
+
+	roasts, err := parse("roasts", "tag", pack.Roasts, knownTag)
+	if err != nil {
+		return err
+	}
+	intros, err := parse("intros", "complexity", pack.Intros, complexity)
+	if err != nil {
+		return err
+	}
+	outros, err := parse("outros", "complexity", pack.Outros, complexity)
+	if err != nil {
+		return err
 	}
 
-	if len(patterns.TimeWasters) > 0 {
-		waster := patterns.TimeWasters[0]
-		basicRoasts = append(basicRoasts, fmt.Sprintf("I see you're visiting %s. Working hard or hardly working, eh?", waster))
+	for key, templates := range roasts {
+		c.roasts[key] = append(c.roasts[key], templates...)
+	}
+	for key, templates := range intros {
+		c.intros[key] = append(c.intros[key], templates...)
+	}
+	for key, templates := range outros {
+		c.outros[key] = append(c.outros[key], templates...)
 	}
+	return nil
+}
 
-	// Add skill level roasts
-	skill := patterns.SkillLevel
-	if skill == "beginner" {
-		basicRoasts = append(basicRoasts, "Your command history screams 'I just discovered what a terminal is'. How adorable.")
-	} else if skill == "intermediate" {
-		basicRoasts = append(basicRoasts, "Your command history is like a mediocre pizza - it gets the job done but nobody's impressed.")
-	} else {
-		basicRoasts = append(basicRoasts, "Fancy commands! Overcompensating for something or just showing off to nobody?")
+// knownTag reports whether local roasts can be about a tag
+func knownTag(tag string) bool {
+	if _, ok := toolTags[tag]; ok {
+		return true
 	}
+	return slices.Contains(findingTags, tag) || slices.Contains(analysis.ToolFindingKinds(), tag)
+}
+
+// CheckRoastPacks makes sure every roast pack parses, so a broken user pack is
+// reported up front rather than silently left out
+func CheckRoastPacks() error {
+	_, err := loadCorpus()
+	return err
+}
 
-	// Make sure we have at least one roast
-	if len(basicRoasts) == 0 {
-		basicRoasts = append(basicRoasts, "I can't even roast your command history - it's that boring. Try doing something interesting first!")
+// generateLocalRoast generates a roast without using an external AI service,
+// from the templates tagged with what the analysis found
+func generateLocalRoast(base RoastData, findings []roastFinding, complexity ComplexityLevel) string {
+	c, _ := loadCorpus()
+	if c == nil {
+		return boringRoast
 	}
 
 	switch complexity {
 	case SimpleRoast:
-		// Just return a single roast
-		return basicRoasts[rand.Intn(len(basicRoasts))]
+		return compose(c.pickRoasts(findings, 1)...)
 
-	case NormalRoast:
-		// Combine 2 roasts
-		if len(basicRoasts) >= 2 {
-			idx1 := rand.Intn(len(basicRoasts))
-			idx2 := (idx1 + 1 + rand.Intn(len(basicRoasts)-1)) % len(basicRoasts)
-			return basicRoasts[idx1] + " " + basicRoasts[idx2]
+	case ComplexRoast:
+		// Intro, a few roasts, and a conclusion
+		return compose(
+			c.render(c.intros["complex"], base),
+			strings.Join(c.pickRoasts(findings, 3), " "),
+			c.render(c.outros["complex"], base),
+		)
+
+	case BrutalRoast:
+		// Everything there is to say, two roasts to a paragraph, and two conclusions
+		roasts := c.pickRoasts(findings, 6)
+		var paragraphs []string
+		for i := 0; i < len(roasts); i += 2 {
+			paragraphs = append(paragraphs, strings.Join(roasts[i:min(i+2, len(roasts))], " "))
 		}
-		return basicRoasts[0]
 
-	case ComplexRoast:
-		// Build a more complex roast with intro and conclusion
-		result := "Well, well, well. Looking at your command history is like reading a diary of technical confusion.\n\n"
+		outros := rand.Perm(len(c.outros["brutal"]))
+		var conclusions []string
+		for _, i := range outros[:min(2, len(outros))] {
+			conclusions = append(conclusions, c.render(c.outros["brutal"][i:i+1], base))
+		}
+
+		parts := append([]string{c.render(c.intros["brutal"], base)}, paragraphs...)
+		return compose(append(parts, conclusions...)...)
+	}
+
+	// Two roasts about different things
+	return compose(strings.Join(c.pickRoasts(findings, 2), " "))
+}
+
+// compose joins the parts of a roast into paragraphs, skipping empty ones
+func compose(parts ...string) string {
+	var paragraphs []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			paragraphs = append(paragraphs, part)
+		}
+	}
+	if len(paragraphs) == 0 {
+		return boringRoast
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// pickRoasts renders up to n roasts, each about a different finding. Generic
+// roasts only fill in once the specific findings run out.
+func (c *roastCorpus) pickRoasts(findings []roastFinding, n int) []string {
+	byTag := make(map[string][]RoastData)
+	var tags []string
+	for _, f := range findings {
+		if len(c.roasts[f.tag]) == 0 {
+			continue
+		}
+		if _, ok := byTag[f.tag]; !ok && f.tag != "generic" {
+			tags = append(tags, f.tag)
+		}
+		byTag[f.tag] = append(byTag[f.tag], f.data)
+	}
+
+	// Weighted random order: what the analysis found comes up more often than
+	// which tools were used, since there are so many more tools
+	keys := make(map[string]float64, len(tags))
+	for _, tag := range tags {
+		weight := findingWeight
+		if _, ok := toolTags[tag]; ok {
+			weight = 1
+		}
+		keys[tag] = -math.Log(1-rand.Float64()) / weight
+	}
+	sort.Slice(tags, func(i, j int) bool { return keys[tags[i]] < keys[tags[j]] })
 
-		// Add 2-3 specific roasts
-		numRoasts := min(len(basicRoasts), 3)
-		usedIndices := map[int]bool{}
+	var roasts []string
+	for _, tag := range tags {
+		if len(roasts) == n {
+			return roasts
+		}
+		data := byTag[tag][rand.IntN(len(byTag[tag]))]
+		if text := c.render(c.roasts[tag], data); text != "" {
+			roasts = append(roasts, text)
+		}
+	}
 
-		for i := 0; i < numRoasts; i++ {
-			idx := rand.Intn(len(basicRoasts))
-			for usedIndices[idx] {
-				idx = rand.Intn(len(basicRoasts))
+	// Different generic roasts for whatever is left
+	if generic := byTag["generic"]; len(generic) > 0 {
+		for _, i := range rand.Perm(len(c.roasts["generic"])) {
+			if len(roasts) == n {
+				break
 			}
-			usedIndices[idx] = true
-			result += basicRoasts[idx] + " "
+			if text := c.render(c.roasts["generic"][i:i+1], generic[0]); text != "" {
+				roasts = append(roasts, text)
+			}
+		}
+	}
+	return roasts
+}
+
+// render fills in a random template from the list, or returns an empty string
+// if none of them can be filled in
+func (c *roastCorpus) render(templates []*template.Template, data RoastData) string {
+	for _, i := range rand.Perm(len(templates)) {
+		var buf bytes.Buffer
+		if err := templates[i].Execute(&buf, data); err != nil {
+			continue
+		}
+		if text := strings.TrimSpace(buf.String()); text != "" {
+			return text
 		}
+	}
+	return ""
+}
 
-		result += "\n\nMaybe someday you'll graduate to actually knowing what you're doing in the terminal, but today is clearly not that day."
-		return result
+// localFindings lists what local roasts can be about, with the numbers to fill
+// their templates in with, and the data every template gets
+func localFindings(patterns analysis.CommandPattern, commands []string) (RoastData, []roastFinding) {
+	base := RoastData{Total: len(commands), Skill: patterns.SkillLevel, Patterns: patterns}
+	lines := shell.ParseAll(commands)
+	percent := func(count int) int {
+		if len(commands) == 0 {
+			return 0
+		}
+		return count * 100 / len(commands)
+	}
 
-	case BrutalRoast:
-		// Build a comprehensive multi-paragraph roast
-		result := "I've seen some sad terminal histories in my time, but yours takes the award for 'Most Likely To Make Linus Torvalds Weep.'\n\n"
-
-		// Use all available roasts
-		for i, roast := range basicRoasts {
-			result += roast + " "
-			if (i+1)%2 == 0 {
-				result += "\n\n"
+	var findings []roastFinding
+	add := func(tag string, fill func(*RoastData)) {
+		data := base
+		fill(&data)
+		findings = append(findings, roastFinding{tag: tag, data: data})
+	}
+
+	add("generic", func(*RoastData) {})
+
+	repeated := append([]analysis.CommandCount(nil), patterns.RepeatedCommands...)
+	sort.SliceStable(repeated, func(i, j int) bool { return repeated[i].Count > repeated[j].Count })
+	for _, r := range repeated[:min(maxLocalFindings, len(repeated))] {
+		add("repeated", func(d *RoastData) {
+			d.Command, d.Count, d.Percent = r.Command, r.Count, percent(r.Count)
+			d.Example = lastCommand(lines, func(c shell.Command) bool { return c.Name == r.Command })
+		})
+	}
+
+	for _, cmd := range recentDistinct(patterns.FailedCommands, maxLocalFindings) {
+		add("failed", func(d *RoastData) {
+			d.Command, d.Example, d.Count = shortenCommand(cmd), cmd, len(patterns.FailedCommands)
+		})
+	}
+
+	for _, cmd := range recentDistinct(patterns.ComplexCommands, maxLocalFindings) {
+		add("complex", func(d *RoastData) {
+			d.Command, d.Example, d.Count = shortenCommand(cmd), cmd, len(patterns.ComplexCommands)
+		})
+	}
+
+	if patterns.Indecisive {
+		wandering := 0
+		for _, line := range lines {
+			if first, ok := line.First(); ok && (first.Name == "cd" || first.Name == "ls") {
+				wandering++
 			}
 		}
+		add("indecisive", func(d *RoastData) { d.Count, d.Percent = wandering, percent(wandering) })
+	}
+
+	for _, waster := range patterns.TimeWasters {
+		add("timewaster", func(d *RoastData) { d.Waster = waster })
+	}
+
+	if patterns.SkillLevel != "" {
+		add(patterns.SkillLevel, func(*RoastData) {})
+	}
+
+	if len(patterns.Shells) > 1 {
+		names := make([]string, 0, len(patterns.Shells))
+		for name := range patterns.Shells {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return patterns.Shells[names[i]] > patterns.Shells[names[j]] })
+		shells := strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+		add("shells", func(d *RoastData) { d.Shells = shells })
+	}
+
+	for _, f := range patterns.ToolFindings {
+		add(f.Kind, func(d *RoastData) {
+			d.Command, d.Example, d.Count, d.Summary = shortenCommand(f.Example), f.Example, f.Count, f.Summary
+		})
+	}
+
+	findings = append(findings, toolFindings(lines, base, percent)...)
+	return base, findings
+}
+
+// toolFindings returns a finding for each tool in the history, about the
+// program from it that was run the most
+func toolFindings(lines []shell.Line, base RoastData, percent func(int) int) []roastFinding {
+	programs := make(map[string]int)
+	examples := make(map[string]string)
+	sudo := 0
+	for _, line := range lines {
+		first, ok := line.First()
+		if !ok {
+			continue
+		}
+		if first.Sudo() {
+			sudo++
+			examples["sudo"] = line.Text
+		}
+		programs[first.Name]++
+		examples[first.Name] = line.Text
+	}
+
+	var findings []roastFinding
+	if sudo >= minToolUses {
+		data := base
+		data.Command, data.Count, data.Percent, data.Example = "sudo", sudo, percent(sudo), examples["sudo"]
+		findings = append(findings, roastFinding{tag: "sudo", data: data})
+	}
+
+	tags := make([]string, 0, len(toolTags))
+	for tag := range toolTags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
 
-		result += "\n\nIf your code is anything like your command history, I'm guessing Stack Overflow isn't just a website for you - it's a lifeline. Your terminal doesn't need a history feature; it needs therapy after what you've put it through."
+	for _, tag := range tags {
+		data := base
+		for _, program := range toolTags[tag] {
+			if programs[program] > 0 && (data.Command == "" || programs[program] > programs[data.Command]) {
+				data.Command, data.Example = program, examples[program]
+			}
+			data.Count += programs[program]
+		}
+		if data.Count >= minToolUses {
+			data.Percent = percent(data.Count)
+			findings = append(findings, roastFinding{tag: tag, data: data})
+		}
+	}
+	return findings
+}
 
-		result += "\n\nThe good news? Even trained monkeys eventually learn which buttons not to push. There's hope for you yet."
+// lastCommand returns the most recent entry whose first command matches, or an empty string
+func lastCommand(lines []shell.Line, match func(c shell.Command) bool) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if first, ok := lines[i].First(); ok && match(first) {
+			return lines[i].Text
+		}
+	}
+	return ""
+}
 
-		return result
+// recentDistinct returns up to n commands, most recent first, skipping reruns
+// that only differ in numbers or spacing
+func recentDistinct(commands []string, n int) []string {
+	var recent []string
+	seen := make(map[string]bool)
+	for i := len(commands) - 1; i >= 0 && len(recent) < n; i-- {
+		if shape := commandShape(commands[i]); !seen[shape] {
+			seen[shape] = true
+			recent = append(recent, commands[i])
+		}
 	}
+	return recent
+}
 
-	// Default fallback
-	return basicRoasts[rand.Intn(len(basicRoasts))]
+// shortenCommand cuts a long command down to something that reads in a sentence
+func shortenCommand(cmd string) string {
+	cmd = strings.Join(strings.Fields(cmd), " ")
+	if runes := []rune(cmd); len(runes) > maxRoastCommandLength {
+		return string(runes[:maxRoastCommandLength-3]) + "..."
+	}
+	return cmd
 }
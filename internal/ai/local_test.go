@@ -0,0 +1,183 @@
+package ai
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+)
+
+// unfilledRe matches what a template leaves behind when a slot it uses is
+// empty: missing values, empty quotes or brackets, doubled spaces and commas
+// with nothing before them
+var unfilledRe = regexp.MustCompile(`<no value>|%!|(^|\s)(''|""|\(\))([\s.,!?]|$)|\S  \S| [,;]|^[,;.]`)
+
+// corpusHistory has every tool roasts are about used often enough to count,
+// so each tag gets a finding
+func corpusHistory() []string {
+	var commands []string
+	for range minToolUses {
+		commands = append(commands,
+			"git commit -m 'fix parser'", "docker ps", "kubectl get pods", "npm test", "python3 app.py",
+			"ssh deploy@web1", "sudo apt update", "vim main.go", "clear", "make build", "man tar",
+			"history", "curl -s https://example.com", "rm -rf build",
+		)
+	}
+	return append(commands, "cd src", "ls", "cd ..", "ls")
+}
+
+// corpusFindings returns findings for every tag with a template, filling tool
+// habit roasts with an example finding of their kind
+func corpusFindings(t *testing.T) (RoastData, map[string]RoastData) {
+	t.Helper()
+
+	patterns := promptPatterns()
+	for _, kind := range analysis.ToolFindingKinds() {
+		patterns.ToolFindings = append(patterns.ToolFindings, analysis.ToolFinding{
+			Tool: strings.SplitN(kind, "-", 2)[0], Kind: kind, Summary: "did the thing", Count: 4,
+			Example: "git push --force origin main",
+		})
+	}
+
+	byTag := make(map[string]RoastData)
+	var base RoastData
+	// Only one skill level comes out of an analysis, so go through all of them
+	for _, skill := range []string{"beginner", "intermediate", "advanced"} {
+		patterns.SkillLevel = skill
+		var findings []roastFinding
+		base, findings = localFindings(patterns, corpusHistory())
+		for _, f := range findings {
+			if _, ok := byTag[f.tag]; !ok {
+				byTag[f.tag] = f.data
+			}
+		}
+	}
+	return base, byTag
+}
+
+// renderEach executes every template, failing the test on any that errors or
+// leaves a slot unfilled
+func renderEach(t *testing.T, section string, templates []*template.Template, data RoastData) {
+	t.Helper()
+
+	for i, tmpl := range templates {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Errorf("%s #%d: %v", section, i+1, err)
+			continue
+		}
+		text := strings.TrimSpace(buf.String())
+		if text == "" || unfilledRe.MatchString(text) {
+			t.Errorf("%s #%d has an unfilled slot: %q", section, i+1, text)
+		}
+	}
+}
+
+func TestEmbeddedRoastsRender(t *testing.T) {
+	userPrompts(t)
+	c, err := parseCorpus()
+	if err != nil {
+		t.Fatalf("parseCorpus: %v", err)
+	}
+	base, byTag := corpusFindings(t)
+
+	total := 0
+	for tag, templates := range c.roasts {
+		data, ok := byTag[tag]
+		if !ok {
+			t.Errorf("no finding has the tag %q, so its %d roasts can never be used", tag, len(templates))
+			continue
+		}
+		renderEach(t, "roasts "+tag, templates, data)
+		total += len(templates)
+	}
+	for _, complexity := range []string{"complex", "brutal"} {
+		renderEach(t, "intros "+complexity, c.intros[complexity], base)
+		renderEach(t, "outros "+complexity, c.outros[complexity], base)
+	}
+
+	if total < 300 {
+		t.Errorf("the embedded packs have %d roasts, want hundreds", total)
+	}
+}
+
+func TestGenerateLocalRoast(t *testing.T) {
+	userPrompts(t)
+	base, findings := localFindings(promptPatterns(), corpusHistory())
+
+	for _, complexity := range []ComplexityLevel{SimpleRoast, NormalRoast, ComplexRoast, BrutalRoast} {
+		for range 20 {
+			roast := generateLocalRoast(base, findings, complexity)
+			if roast == boringRoast || strings.Contains(roast, "<no value>") {
+				t.Fatalf("%v roast = %q, want one about the findings", complexity, roast)
+			}
+			if paragraphs := strings.Count(roast, "\n\n") + 1; complexity == BrutalRoast && paragraphs < 4 {
+				t.Errorf("brutal roast has %d paragraphs, want at least 4:\n%s", paragraphs, roast)
+			}
+		}
+	}
+
+	// Nothing found means nothing to say
+	if roast := generateLocalRoast(RoastData{}, nil, SimpleRoast); roast != boringRoast {
+		t.Errorf("roast with no findings = %q, want the boring one", roast)
+	}
+}
+
+func TestUserRoastPacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string // Part of the error message expected, if any
+	}{
+		{name: "yaml", file: "extra.yaml", content: "roasts:\n  git:\n    - \"{{.Command}}: a user pack roast.\"\n"},
+		{name: "json", file: "extra.json", content: `{"roasts": {"git": ["{{.Command}}: a user pack roast."]}}`},
+		{name: "unknown tag", file: "extra.yml", content: "roasts:\n  gti:\n    - \"typo\"\n", want: `unknown tag "gti"`},
+		{name: "unknown complexity", file: "extra.yaml", content: "intros:\n  simple:\n    - \"hi\"\n", want: `unknown complexity "simple"`},
+		{name: "missing field", file: "extra.yaml", content: "roasts:\n  git:\n    - \"{{.Commnad}}\"\n", want: "roasts git #1"},
+		{name: "not yaml", file: "extra.yaml", content: "roasts: [", want: "roast pack"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(filepath.Dir(userPrompts(t)), "packs")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := parseCorpus()
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("err = %v, want it to mention %q", err, tt.want)
+				}
+				// The built-in packs still load
+				if c == nil || len(c.roasts["git"]) == 0 {
+					t.Error("a broken user pack lost the built-in roasts")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCorpus: %v", err)
+			}
+
+			var rendered []string
+			for _, tmpl := range c.roasts["git"] {
+				var buf bytes.Buffer
+				if err := tmpl.Execute(&buf, RoastData{Command: "git"}); err == nil {
+					rendered = append(rendered, buf.String())
+				}
+			}
+			if !strings.Contains(strings.Join(rendered, "\n"), "git: a user pack roast.") {
+				t.Error("the user pack's roast wasn't added")
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// similarityThreshold is the shingle overlap above which two roasts count as
+// the same roast reworded
+const similarityThreshold = 0.5
+
+// shingleSize is how many words make up a shingle
+const shingleSize = 3
+
+// localAttempts bounds how many built-in roasts are tried looking for a new one
+const localAttempts = 100
+
+// regenerateAttempts bounds how many times an AI roast that repeats an earlier
+// one is asked for again
+const regenerateAttempts = 1
+
+// repeatNote is added to the prompt when regenerating a roast that came out
+// too close to an earlier one
+const repeatNote = "\n\nThat came out too close to a roast you already gave:\n%q\nWrite something completely different."
+
+// topicKeywords maps kinds of roast to words that show a roast was about them
+var topicKeywords = []struct {
+	topic    string
+	keywords []string
+}{
+	{"typos and failed commands", []string{"typo", "typos", "failed", "fail", "failing"}},
+	{"complex one-liners", []string{"one-liner", "one-liners", "pipe", "pipes", "pipeline", "pipelines"}},
+	{"wandering around with cd and ls", []string{"lost", "wandering", "indecisive", "exploring"}},
+	{"skill level", []string{"skill", "beginner", "newbie", "noob", "intermediate"}},
+}
+
+// sessionMemory remembers the roasts delivered in a session, so they aren't
+// repeated word for word or reworded
+type sessionMemory struct {
+	delivered map[string]bool
+	roasts    []string
+	shingles  []map[string]bool
+	topics    []string
+}
+
+// newSessionMemory returns an empty memory
+func newSessionMemory() *sessionMemory {
+	return &sessionMemory{delivered: make(map[string]bool)}
+}
+
+// add remembers a delivered roast and what it was about
+func (m *sessionMemory) add(roast string, patterns analysis.CommandPattern) {
+	if m == nil {
+		return
+	}
+
+	m.delivered[roast] = true
+	m.roasts = append(m.roasts, roast)
+	m.shingles = append(m.shingles, shingles(roast))
+
+	for _, topic := range roastTopics(roast, patterns) {
+		if !slices.Contains(m.topics, topic) {
+			m.topics = append(m.topics, topic)
+		}
+	}
+}
+
+// covered returns the topics earlier roasts were about, in the order they came up
+func (m *sessionMemory) covered() []string {
+	if m == nil {
+		return nil
+	}
+	return m.topics
+}
+
+// similar returns the earlier roast that a new one is a near-duplicate of, if any
+func (m *sessionMemory) similar(roast string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+
+	if m.delivered[roast] {
+		return roast, true
+	}
+
+	current := shingles(roast)
+	for i, earlier := range m.shingles {
+		if jaccard(current, earlier) >= similarityThreshold {
+			return m.roasts[i], true
+		}
+	}
+	return "", false
+}
+
+// localRoast returns a built-in roast that hasn't been delivered yet, if one
+// can be found
+func (m *sessionMemory) localRoast(patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel) string {
+	base, findings := localFindings(patterns, commands)
+	roast := generateLocalRoast(base, findings, complexity)
+	for attempt := 1; m != nil && m.delivered[roast] && attempt < localAttempts; attempt++ {
+		roast = generateLocalRoast(base, findings, complexity)
+	}
+	return roast
+}
+
+// avoidRepeats asks the provider again, steering it away from the earlier
+// roast, when a roast is a near-duplicate of one already delivered. If that
+// fails the original roast is kept, since a repeat beats no roast at all.
+func (m *sessionMemory) avoidRepeats(ctx context.Context, cfg config.Config, req Request, text string, stream StreamFunc) (string, error) {
+	for attempt := 0; attempt < regenerateAttempts; attempt++ {
+		earlier, ok := m.similar(text)
+		if !ok {
+			break
+		}
+
+		if stream != nil {
+			stream("")
+		}
+
+		steered := req
+		steered.Prompt += fmt.Sprintf(repeatNote, earlier)
+		regenerated, err := generateWithRetries(ctx, cfg, steered, stream)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			break
+		}
+		text = regenerated
+	}
+	return text, nil
+}
+
+// roastTopics works out which findings and commands a roast talked about
+func roastTopics(roast string, patterns analysis.CommandPattern) []string {
+	words := make(map[string]bool)
+	for _, word := range roastWords(roast) {
+		words[word] = true
+	}
+
+	var topics []string
+	for _, t := range topicKeywords {
+		for _, keyword := range t.keywords {
+			if words[keyword] {
+				topics = append(topics, t.topic)
+				break
+			}
+		}
+	}
+
+	for _, repeated := range patterns.RepeatedCommands {
+		if name := strings.ToLower(repeated.Command); words[name] && !slices.Contains(topics, name) {
+			topics = append(topics, name)
+		}
+	}
+	for _, waster := range patterns.TimeWasters {
+		if words[waster] && !slices.Contains(topics, waster) {
+			topics = append(topics, waster)
+		}
+	}
+
+	return topics
+}
+
+// shingles returns the sets of consecutive words in a roast, which survive
+// light rewording better than comparing whole sentences
+func shingles(roast string) map[string]bool {
+	words := roastWords(roast)
+	set := make(map[string]bool)
+	if len(words) < shingleSize {
+		set[strings.Join(words, " ")] = true
+		return set
+	}
+
+	for i := 0; i+shingleSize <= len(words); i++ {
+		set[strings.Join(words[i:i+shingleSize], " ")] = true
+	}
+	return set
+}
+
+// roastWords splits a roast into lowercase words, keeping the characters that
+// show up in command names
+func roastWords(roast string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(roast), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.'
+	})
+
+	words := fields[:0]
+	for _, field := range fields {
+		// A full stop ends the sentence, not the command
+		if word := strings.Trim(field, ".-_"); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// jaccard returns how much two sets overlap, from 0 to 1
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for s := range a {
+		if b[s] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
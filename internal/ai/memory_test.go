@@ -0,0 +1,139 @@
+package ai
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+func TestSessionMemorySimilar(t *testing.T) {
+	m := newSessionMemory()
+	delivered := "Your git log reads like a ransom note written by a raccoon."
+	m.add(delivered, analysis.CommandPattern{})
+
+	tests := []struct {
+		roast   string
+		similar bool
+	}{
+		{roast: delivered, similar: true},
+		{roast: "Honestly, your git log reads like a ransom note written by a raccoon!", similar: true},
+		{roast: "YOUR GIT LOG READS LIKE A RANSOM NOTE WRITTEN BY A RACCOON", similar: true},
+		{roast: "Your git log reads like a ransom note. Your Dockerfile reads like a confession.", similar: false},
+		{roast: "You typed gti four times, and it never became git.", similar: false},
+		{roast: "", similar: false},
+	}
+
+	for _, tt := range tests {
+		earlier, similar := m.similar(tt.roast)
+		if similar != tt.similar {
+			t.Errorf("similar(%q) = %v, want %v", tt.roast, similar, tt.similar)
+		}
+		if similar && earlier != delivered {
+			t.Errorf("similar(%q) matched %q, want the delivered roast", tt.roast, earlier)
+		}
+	}
+
+	// A conversation without a memory never finds a repeat
+	var none *sessionMemory
+	if _, similar := none.similar(delivered); similar {
+		t.Error("a nil memory found a repeat")
+	}
+}
+
+func TestSessionMemoryTopics(t *testing.T) {
+	patterns := analysis.CommandPattern{
+		RepeatedCommands: []analysis.CommandCount{{Command: "git", Count: 40}, {Command: "ls", Count: 30}},
+		TimeWasters:      []string{"reddit"},
+	}
+
+	m := newSessionMemory()
+	m.add("Forty runs of git and still a typo in every commit message.", patterns)
+	m.add("You check reddit between failed builds, and git again after that.", patterns)
+
+	want := []string{"typos and failed commands", "git", "reddit"}
+	if got := m.covered(); !reflect.DeepEqual(got, want) {
+		t.Errorf("covered = %q, want %q", got, want)
+	}
+}
+
+func TestConversationRegeneratesNearDuplicate(t *testing.T) {
+	userPrompts(t)
+	server := newChatServer(t,
+		"Your git log reads like a ransom note written by a raccoon.",
+		"Honestly, your git log reads like a ransom note written by a raccoon!",
+		"Forty commits called wip is not a workflow, it's a cry for help.",
+	)
+	conv := NewConversation(server.config())
+	commands := []string{"git commit -m wip", "git push --force"}
+	ctx := context.Background()
+
+	if _, err := conv.Roast(ctx, promptPatterns(), commands, SimpleRoast, nil); err != nil {
+		t.Fatalf("first roast: %v", err)
+	}
+	roast, err := conv.Roast(ctx, promptPatterns(), commands, SimpleRoast, nil)
+	if err != nil {
+		t.Fatalf("second roast: %v", err)
+	}
+
+	if want := server.replies[2]; roast.Text != want {
+		t.Errorf("second roast = %q, want the regenerated %q", roast.Text, want)
+	}
+	if len(server.requests) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(server.requests))
+	}
+
+	// The regenerated request quotes the roast it came too close to
+	messages := server.requests[2]
+	prompt := messages[len(messages)-1].Content
+	if !strings.Contains(prompt, "too close to a roast you already gave") || !strings.Contains(prompt, server.replies[0]) {
+		t.Errorf("regenerated prompt doesn't steer away from the earlier roast:\n%s", prompt)
+	}
+}
+
+func TestConversationSendsCoveredTopics(t *testing.T) {
+	userPrompts(t)
+	server := newChatServer(t,
+		"Every other command is a typo. Your keyboard files complaints.",
+		"Twelve runs of git status and you still don't know what changed.",
+	)
+	conv := NewConversation(server.config())
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := conv.Roast(ctx, promptPatterns(), []string{"gti status", "git status"}, SimpleRoast, nil); err != nil {
+			t.Fatalf("Roast: %v", err)
+		}
+	}
+
+	first, second := server.requests[0], server.requests[1]
+	if prompt := first[len(first)-1].Content; strings.Contains(prompt, "Topics already covered") {
+		t.Errorf("first prompt lists covered topics before any roast:\n%s", prompt)
+	}
+	if prompt := second[len(second)-1].Content; !strings.Contains(prompt, "Topics already covered: typos and failed commands") {
+		t.Errorf("second prompt doesn't list the covered topic:\n%s", prompt)
+	}
+}
+
+func TestLocalRoastsDontRepeat(t *testing.T) {
+	userPrompts(t)
+	var cfg config.Config
+	cfg.AI.Provider = "local"
+	conv := NewConversation(cfg)
+	commands := []string{"git status", "gti status", "git commit -m wip", "cd src", "ls", "cd ..", "ls"}
+
+	seen := make(map[string]bool)
+	for i := range 10 {
+		roast, err := conv.Roast(context.Background(), promptPatterns(), commands, SimpleRoast, nil)
+		if err != nil {
+			t.Fatalf("Roast: %v", err)
+		}
+		if seen[roast.Text] {
+			t.Fatalf("roast %d repeats an earlier one: %q", i+1, roast.Text)
+		}
+		seen[roast.Text] = true
+	}
+}
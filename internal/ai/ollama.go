@@ -0,0 +1,119 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/ollama"
+)
+
+// defaultOllamaHost is where Ollama listens unless told otherwise
+const defaultOllamaHost = "http://localhost:11434"
+
+// ollamaCheckTimeout bounds the availability check, which should be instant for a local server
+const ollamaCheckTimeout = 5 * time.Second
+
+// initOllama initializes a client for a local or remote Ollama server, after
+// checking that the server is up and the model has been pulled
+func initOllama(ctx context.Context, cfg config.Config) (llms.Model, error) {
+	if cfg.AI.Ollama.Model == "" {
+		return nil, errors.New("ollama model not configured")
+	}
+
+	host, err := ollamaHost(cfg.AI.Ollama.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOllamaModel(ctx, host, cfg.AI.Ollama.Model); err != nil {
+		return nil, err
+	}
+
+	return ollama.New(
+		ollama.WithServerURL(host),
+		ollama.WithModel(cfg.AI.Ollama.Model),
+	)
+}
+
+// ollamaHost returns the configured host, falling back to $OLLAMA_HOST and then
+// the default. A bare host:port is accepted, as it is by the ollama CLI.
+func ollamaHost(host string) (string, error) {
+	if host == "" {
+		host = os.Getenv("OLLAMA_HOST")
+	}
+	if host == "" {
+		host = defaultOllamaHost
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+
+	u, err := url.Parse(host)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid ollama host %q", host)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// ollamaTags is the response of Ollama's /api/tags, which lists pulled models
+type ollamaTags struct {
+	Models []struct {
+		Name  string `json:"name"`
+		Model string `json:"model"`
+	} `json:"models"`
+}
+
+// checkOllamaModel makes sure the server is reachable and has the model, so a
+// missing pull is reported clearly rather than as a generic request failure
+func checkOllamaModel(ctx context.Context, host, model string) error {
+	ctx, cancel := context.WithTimeout(ctx, ollamaCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &ProviderError{Provider: "ollama", Kind: ErrNetwork, Err: fmt.Errorf("can't reach %s (is `ollama serve` running?): %v", host, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama at %s returned %s", host, resp.Status)
+	}
+
+	var tags ollamaTags
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("unexpected response from ollama at %s: %v", host, err)
+	}
+
+	for _, m := range tags.Models {
+		if ollamaModelMatches(m.Name, model) || ollamaModelMatches(m.Model, model) {
+			return nil
+		}
+	}
+	return &ProviderError{Provider: "ollama", Kind: ErrModelNotFound, Err: fmt.Errorf("%q is not pulled; run `ollama pull %s` first", model, model)}
+}
+
+// ollamaModelMatches reports whether a pulled model name satisfies the configured
+// one. Ollama treats a name without a tag as the "latest" tag.
+func ollamaModelMatches(pulled, want string) bool {
+	if pulled == want {
+		return true
+	}
+	if !strings.Contains(want, ":") {
+		return pulled == want+":latest"
+	}
+	return false
+}
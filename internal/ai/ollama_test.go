@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// fakeOllama serves /api/tags listing the given models
+func fakeOllama(models ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var list []string
+		for _, m := range models {
+			list = append(list, `{"name": "`+m+`", "model": "`+m+`"}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"models": [` + strings.Join(list, ",") + `]}`))
+	}))
+}
+
+func TestInitOllama(t *testing.T) {
+	pulled := fakeOllama("llama3.2:latest", "qwen2.5:7b")
+	defer pulled.Close()
+
+	// A server that has gone away leaves a port nothing listens on
+	gone := fakeOllama()
+	gone.Close()
+
+	tests := []struct {
+		name  string
+		host  string
+		model string
+		kind  error  // Kind of ProviderError expected, nil for success
+		hint  string // Part of the error message expected
+	}{
+		{name: "model is pulled", host: pulled.URL, model: "llama3.2"},
+		{name: "model with tag is pulled", host: pulled.URL, model: "qwen2.5:7b"},
+		{name: "model is missing", host: pulled.URL, model: "mistral", kind: ErrModelNotFound, hint: "ollama pull mistral"},
+		{name: "other tag is missing", host: pulled.URL, model: "qwen2.5:14b", kind: ErrModelNotFound, hint: "ollama pull qwen2.5:14b"},
+		{name: "host unreachable", host: gone.URL, model: "llama3.2", kind: ErrNetwork, hint: "ollama serve"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config.Config
+			cfg.AI.Ollama = config.AIProviderConfig{BaseURL: tt.host, Model: tt.model}
+
+			llm, err := initOllama(context.Background(), cfg)
+			if tt.kind == nil {
+				if err != nil || llm == nil {
+					t.Fatalf("initOllama = %v, %v; want a client", llm, err)
+				}
+				return
+			}
+
+			var perr *ProviderError
+			if !errors.As(err, &perr) || !errors.Is(err, tt.kind) {
+				t.Fatalf("initOllama error = %v, want a %v provider error", err, tt.kind)
+			}
+			if !strings.Contains(err.Error(), tt.hint) {
+				t.Errorf("error %q doesn't mention %q", err, tt.hint)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package ai
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// builtinPrompts holds the default prompt templates and personas. A file of the
+// same name in the user's prompts directory replaces the built-in one.
+//
+//go:embed prompts
+var builtinPrompts embed.FS
+
+// defaultPersona is used when no persona is configured
+const defaultPersona = "default"
+
+// PromptData is what prompt and persona templates are executed with
+type PromptData struct {
+	Commands      []string                // Sampled commands, oldest first
+	TotalCommands int                     // How many commands were analyzed before sampling
+	Patterns      analysis.CommandPattern // Everything the analysis found
+	Complexity    string                  // simple, normal, complex or brutal
+	Persona       string                  // Name of the persona in use
+	Message       string                  // What the user said, when replying to them
+	Covered       []string                // Topics earlier roasts in the session were about
+}
+
+// promptFuncs are the helpers available in templates
+var promptFuncs = template.FuncMap{
+	"bullets": formatCommands,
+	"shells":  formatShells,
+	"join":    strings.Join,
+	"times":   times,
+}
+
+// times spells out a count the way a sentence would: once, twice, 3 times
+func times(count int) string {
+	switch count {
+	case 1:
+		return "once"
+	case 2:
+		return "twice"
+	}
+	return fmt.Sprintf("%d times", count)
+}
+
+// String returns the complexity's name, which is also the name of its prompt template
+func (c ComplexityLevel) String() string {
+	switch c {
+	case SimpleRoast:
+		return "simple"
+	case ComplexRoast:
+		return "complex"
+	case BrutalRoast:
+		return "brutal"
+	}
+	return "normal"
+}
+
+// promptsDir returns the directory where users keep their own templates
+func promptsDir() string {
+	dir, err := config.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "prompts")
+}
+
+// loadPrompts parses the prompt templates, user files taking precedence, along
+// with the persona, which becomes the template named "persona"
+func loadPrompts(persona string) (*template.Template, error) {
+	if persona == "" {
+		persona = defaultPersona
+	}
+
+	root := template.New("").Funcs(promptFuncs)
+	parse := func(name, source string, content []byte) error {
+		if _, err := root.New(name).Parse(string(content)); err != nil {
+			return fmt.Errorf("prompt template %s: %v", source, err)
+		}
+		return nil
+	}
+
+	builtin, err := fs.Glob(builtinPrompts, "prompts/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range builtin {
+		content, err := builtinPrompts.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := parse(templateName(file), file, content); err != nil {
+			return nil, err
+		}
+	}
+
+	// User templates are parsed after the built-in ones, so they replace them
+	if dir := promptsDir(); dir != "" {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if err := parse(templateName(file), file, content); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	content, source, err := readPersona(persona)
+	if err != nil {
+		return nil, err
+	}
+	if err := parse("persona", source, content); err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// readPersona returns a persona's template, preferring the user's own
+func readPersona(name string) ([]byte, string, error) {
+	if strings.ContainsAny(name, `/\`) {
+		return nil, "", fmt.Errorf("invalid persona name %q", name)
+	}
+
+	if dir := promptsDir(); dir != "" {
+		file := filepath.Join(dir, "personas", name+".tmpl")
+		content, err := os.ReadFile(file)
+		if err == nil {
+			return content, file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, "", err
+		}
+	}
+
+	file := path.Join("prompts", "personas", name+".tmpl")
+	content, err := builtinPrompts.ReadFile(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown persona %q (available: %s)", name, strings.Join(Personas(), ", "))
+	}
+	return content, file, nil
+}
+
+// Personas returns the names of the built-in personas and any the user has added
+func Personas() []string {
+	seen := make(map[string]bool)
+
+	builtin, _ := fs.Glob(builtinPrompts, "prompts/personas/*.tmpl")
+	var user []string
+	if dir := promptsDir(); dir != "" {
+		user, _ = filepath.Glob(filepath.Join(dir, "personas", "*.tmpl"))
+	}
+
+	var names []string
+	for _, file := range append(builtin, user...) {
+		name := templateName(file)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckPrompts makes sure the configured persona exists and every template
+// parses, so mistakes are reported up front rather than on the first roast
+func CheckPrompts(cfg config.Config) error {
+	_, err := loadPrompts(cfg.AI.Persona)
+	return err
+}
+
+// renderPrompt executes a template, trimming the whitespace template files
+// tend to end with
+func renderPrompt(prompts *template.Template, name string, data PromptData) (string, error) {
+	var buf bytes.Buffer
+	if err := prompts.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", fmt.Errorf("prompt template %s: %v", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// templateName is a template file's name without its directory or extension
+func templateName(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), ".tmpl")
+}
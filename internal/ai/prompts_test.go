@@ -0,0 +1,168 @@
+package ai
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// userPrompts points the config directory at a temporary one and returns its
+// prompts directory
+func userPrompts(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	return filepath.Join(dir, "roastme", "prompts")
+}
+
+// writePrompt writes a user template under the prompts directory
+func writePrompt(t *testing.T, dir, name, content string) {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// promptPatterns is an analysis with something in every field the templates use
+func promptPatterns() analysis.CommandPattern {
+	return analysis.CommandPattern{
+		RepeatedCommands: []analysis.CommandCount{{Command: "git", Count: 12}},
+		FailedCommands:   []string{"gti status"},
+		ComplexCommands:  []string{"find . -name '*.go' | xargs grep -l TODO | wc -l"},
+		Indecisive:       true,
+		TimeWasters:      []string{"reddit"},
+		SkillLevel:       "intermediate",
+		Shells:           map[string]int{"zsh": 40, "bash": 3},
+	}
+}
+
+func TestBuiltinPersonasRender(t *testing.T) {
+	userPrompts(t)
+	commands := []string{"git status", "gti status", "git commit -m wip"}
+
+	personas := Personas()
+	for _, want := range []string{"corporate-hr", "default", "disappointed-senior", "pirate"} {
+		if !strings.Contains(strings.Join(personas, " "), want) {
+			t.Errorf("Personas() = %q, missing %q", personas, want)
+		}
+	}
+
+	systemPrompts := make(map[string]string)
+	for _, persona := range personas {
+		t.Run(persona, func(t *testing.T) {
+			var cfg config.Config
+			cfg.AI.Provider = "openai"
+			cfg.AI.Persona = persona
+			cfg.AI.Seed = 1
+
+			for _, complexity := range []ComplexityLevel{SimpleRoast, NormalRoast, ComplexRoast, BrutalRoast} {
+				req, err := BuildRequest(cfg, promptPatterns(), commands, complexity)
+				if err != nil {
+					t.Fatalf("%v: BuildRequest: %v", complexity, err)
+				}
+				if req.SystemPrompt == "" || req.Prompt == "" {
+					t.Fatalf("%v: empty prompt: %+v", complexity, req)
+				}
+				if strings.Contains(req.SystemPrompt+req.Prompt, "<no value>") {
+					t.Errorf("%v: prompt has an unfilled value:\n%s\n%s", complexity, req.SystemPrompt, req.Prompt)
+				}
+				if !strings.Contains(req.Prompt, "- gti status") {
+					t.Errorf("%v: prompt doesn't list the sampled commands:\n%s", complexity, req.Prompt)
+				}
+				if req.Persona != persona {
+					t.Errorf("%v: Persona = %q, want %q", complexity, req.Persona, persona)
+				}
+			}
+
+			req, _ := BuildRequest(cfg, promptPatterns(), commands, SimpleRoast)
+			for other, prompt := range systemPrompts {
+				if prompt == req.SystemPrompt {
+					t.Errorf("%s has the same system prompt as %s", persona, other)
+				}
+			}
+			systemPrompts[persona] = req.SystemPrompt
+		})
+	}
+}
+
+func TestUserPromptsOverrideBuiltin(t *testing.T) {
+	dir := userPrompts(t)
+	writePrompt(t, dir, "simple.tmpl", "Roast {{.TotalCommands}} commands in {{.Complexity}} mode.\n")
+	writePrompt(t, dir, "personas/default.tmpl", "You are my own {{.Persona}} persona.\n")
+	writePrompt(t, dir, "personas/robot.tmpl", "BEEP. You are a robot.\n")
+
+	var cfg config.Config
+	cfg.AI.Provider = "openai"
+	commands := []string{"ls", "cd src"}
+
+	req, err := BuildRequest(cfg, promptPatterns(), commands, SimpleRoast)
+	if err != nil {
+		t.Fatalf("BuildRequest: %v", err)
+	}
+	if want := "Roast 2 commands in simple mode."; req.Prompt != want {
+		t.Errorf("Prompt = %q, want %q", req.Prompt, want)
+	}
+	if want := "You are my own default persona."; req.SystemPrompt != want {
+		t.Errorf("SystemPrompt = %q, want %q", req.SystemPrompt, want)
+	}
+
+	// Templates the user didn't replace are still the built-in ones
+	req, err = BuildRequest(cfg, promptPatterns(), commands, NormalRoast)
+	if err != nil {
+		t.Fatalf("BuildRequest: %v", err)
+	}
+	if !strings.Contains(req.Prompt, "Skill level: intermediate") {
+		t.Errorf("normal prompt isn't the built-in one:\n%s", req.Prompt)
+	}
+
+	cfg.AI.Persona = "robot"
+	req, err = BuildRequest(cfg, promptPatterns(), commands, SimpleRoast)
+	if err != nil {
+		t.Fatalf("BuildRequest: %v", err)
+	}
+	if req.SystemPrompt != "BEEP. You are a robot." {
+		t.Errorf("SystemPrompt = %q, want the user's persona", req.SystemPrompt)
+	}
+	if personas := strings.Join(Personas(), " "); !strings.Contains(personas, "robot") || strings.Count(personas, "default") != 1 {
+		t.Errorf("Personas() = %q, want robot added and default listed once", personas)
+	}
+}
+
+func TestLoadPromptsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		persona string
+		files   map[string]string
+		want    string
+	}{
+		{name: "unknown persona", persona: "mime", want: `unknown persona "mime" (available: corporate-hr, default`},
+		{name: "persona outside the directory", persona: "../simple", want: `invalid persona name "../simple"`},
+		{name: "broken user template", files: map[string]string{"simple.tmpl": "{{.Commands"}, want: "simple.tmpl"},
+		{name: "broken user persona", persona: "broken", files: map[string]string{"personas/broken.tmpl": "{{if}}"}, want: "broken.tmpl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := userPrompts(t)
+			for name, content := range tt.files {
+				writePrompt(t, dir, name, content)
+			}
+
+			var cfg config.Config
+			cfg.AI.Persona = tt.persona
+			if err := CheckPrompts(cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package ai
+
+import (
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/jasonlovesdoggo/roastme/internal/redact"
+)
+
+// redactForProvider returns copies of the patterns and commands with secrets
+// removed, ready to be put in a prompt for an external AI provider
+func redactForProvider(cfg config.RedactionConfig, patterns analysis.CommandPattern, commands []string) (analysis.CommandPattern, []string, error) {
+	if !cfg.Enabled {
+		return patterns, commands, nil
+	}
+
+	redactor, err := redact.New(cfg)
+	if err != nil {
+		return patterns, commands, err
+	}
+
+	redacted := patterns
+	redacted.RepeatedCommands = make([]analysis.CommandCount, len(patterns.RepeatedCommands))
+	for i, repeated := range patterns.RepeatedCommands {
+		repeated.Command, _ = redactor.Redact(repeated.Command)
+		redacted.RepeatedCommands[i] = repeated
+	}
+	redacted.FailedCommands = redactor.RedactAll(patterns.FailedCommands)
+	redacted.ComplexCommands = redactor.RedactAll(patterns.ComplexCommands)
+	redacted.ToolFindings = make([]analysis.ToolFinding, len(patterns.ToolFindings))
+	for i, finding := range patterns.ToolFindings {
+		finding.Example, _ = redactor.Redact(finding.Example)
+		redacted.ToolFindings[i] = finding
+	}
+
+	return redacted, redactor.RedactAll(commands), nil
+}
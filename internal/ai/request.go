@@ -0,0 +1,162 @@
+package ai
+
+import (
+	"math/rand/v2"
+	"text/template"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Request is everything that gets sent to an AI provider for a single roast
+type Request struct {
+	Provider        string   `json:"provider"`
+	Model           string   `json:"model,omitempty"`
+	Persona         string   `json:"persona"`
+	SystemPrompt    string   `json:"system_prompt"`
+	Prompt          string   `json:"prompt"`
+	SampledCommands []string `json:"sampled_commands"`
+	TotalCommands   int      `json:"total_commands"`
+	MaxTokens       int      `json:"max_tokens"`
+	Temperature     float64  `json:"temperature"`
+	Redacted        bool     `json:"redacted"`
+	PromptTokens    int      `json:"prompt_tokens"`
+	ContextSize     int      `json:"context_size"`
+	Seed            int64    `json:"seed"`
+	// History holds the earlier turns of a conversation, sent before Prompt
+	History []llms.MessageContent `json:"-"`
+}
+
+// BuildRequest assembles the prompt and generation parameters for a roast,
+// exactly as they would be sent to the configured provider
+func BuildRequest(cfg config.Config, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel) (Request, error) {
+	return buildRequest(cfg, patterns, commands, complexity, turn{template: complexity.String()})
+}
+
+// turn is what a conversation adds to a request
+type turn struct {
+	template string                // Prompt template to render
+	message  string                // What the user said, if they said something
+	history  []llms.MessageContent // Earlier turns, sent before the prompt
+	covered  []string              // Topics earlier roasts were about
+}
+
+// buildRequest assembles a request for a turn of a conversation. Earlier turns
+// are dropped, oldest first, when they don't fit in the model's context window.
+func buildRequest(cfg config.Config, patterns analysis.CommandPattern, commands []string, complexity ComplexityLevel, t turn) (Request, error) {
+	// Scrub secrets and personal data before anything leaves the machine
+	patterns, commands, err := redactForProvider(cfg.Redaction, patterns, commands)
+	if err != nil {
+		return Request{}, err
+	}
+
+	prompts, err := loadPrompts(cfg.AI.Persona)
+	if err != nil {
+		return Request{}, err
+	}
+
+	maxTokens, temperature := generationParams(complexity)
+
+	// Sample commands to fill a token budget that leaves room for the
+	// rest of the prompt and the reply in the model's context window
+	count := newTokenCounter(cfg)
+	data := PromptData{
+		TotalCommands: len(commands),
+		Patterns:      trimPatterns(patterns, count),
+		Complexity:    complexity.String(),
+		Persona:       cfg.AI.Persona,
+		Message:       t.message,
+		Covered:       t.covered,
+	}
+	if data.Persona == "" {
+		data.Persona = defaultPersona
+	}
+
+	systemPrompt, prompt, err := renderPrompts(prompts, t.template, data)
+	if err != nil {
+		return Request{}, err
+	}
+	overhead := count(systemPrompt) + count(prompt)
+	history, historyTokens := fitHistory(t.history, historyBudget(cfg, maxTokens, overhead), count)
+	budget := commandBudget(cfg, complexity, maxTokens, overhead+historyTokens)
+
+	// A fresh seed each time varies the sample between roasts, unless one was given
+	seed := cfg.AI.Seed
+	if seed == 0 {
+		seed = rand.Int64()
+	}
+	data.Commands = sampleCommands(commands, patterns, budget, count, seed)
+
+	systemPrompt, prompt, err = renderPrompts(prompts, t.template, data)
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Request{
+		Provider:        cfg.AI.Provider,
+		Model:           providerModel(cfg),
+		Persona:         data.Persona,
+		SystemPrompt:    systemPrompt,
+		Prompt:          prompt,
+		SampledCommands: data.Commands,
+		TotalCommands:   len(commands),
+		MaxTokens:       maxTokens,
+		Temperature:     temperature,
+		Redacted:        cfg.Redaction.Enabled,
+		PromptTokens:    count(systemPrompt) + count(prompt) + historyTokens,
+		ContextSize:     contextSize(cfg),
+		Seed:            seed,
+		History:         history,
+	}, nil
+}
+
+// renderPrompts renders the persona's system prompt and the named prompt
+func renderPrompts(prompts *template.Template, name string, data PromptData) (string, string, error) {
+	systemPrompt, err := renderPrompt(prompts, "persona", data)
+	if err != nil {
+		return "", "", err
+	}
+	prompt, err := renderPrompt(prompts, name, data)
+	if err != nil {
+		return "", "", err
+	}
+	return systemPrompt, prompt, nil
+}
+
+// generationParams returns the max tokens and temperature for a complexity level
+func generationParams(complexity ComplexityLevel) (int, float64) {
+	switch complexity {
+	case SimpleRoast:
+		return 100, 0.5
+	case NormalRoast:
+		return 150, 0.7
+	case ComplexRoast:
+		return 300, 0.8
+	case BrutalRoast:
+		return 500, 0.9
+	}
+	return 150, 0.7
+}
+
+// providerModel returns the model configured for the selected provider
+func providerModel(cfg config.Config) string {
+	return providerConfig(cfg, cfg.AI.Provider).Model
+}
+
+// providerConfig returns the settings every provider shares for the named provider
+func providerConfig(cfg config.Config, provider string) config.AIProviderConfig {
+	switch provider {
+	case "openai":
+		return cfg.AI.OpenAI
+	case "anthropic":
+		return cfg.AI.Anthropic
+	case "gemini":
+		return cfg.AI.Gemini
+	case "custom":
+		return cfg.AI.Custom.AIProviderConfig
+	case "ollama":
+		return cfg.AI.Ollama
+	}
+	return config.AIProviderConfig{}
+}
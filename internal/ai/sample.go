@@ -0,0 +1,307 @@
+package ai
+
+import (
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/shell"
+)
+
+// findingShare is the part of the budget reserved for the commands behind the
+// pattern findings; the rest goes to a representative spread of the history
+const findingShare = 0.5
+
+// minHashLength is the shortest hex word taken for a hash, as long as git's short commit hashes
+const minHashLength = 7
+
+var (
+	// uuidRe finds UUIDs, which change between otherwise identical runs
+	uuidRe = regexp.MustCompile(`\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+	// wordRe finds the words of a command that might be variable
+	wordRe = regexp.MustCompile(`[[:alnum:]]+`)
+)
+
+// sampleEntry is one distinct command in the history, standing in for all of
+// its near-duplicates
+type sampleEntry struct {
+	text    string // Most recent variant, truncated to fit
+	cost    int    // Tokens it takes in the prompt
+	count   int    // How many times it or a near-duplicate was run
+	last    int    // Index of its most recent run, to keep the sample in order
+	family  string // Cluster of similar commands it belongs to
+	program string // Program that ran, e.g. "git" for "sudo git push"
+}
+
+// sampleCommands picks the commands to show the AI within a token budget. The
+// commands behind each pattern finding go in first, then the rest of the budget
+// is shared between clusters of similar commands in proportion to how often
+// they were run. Near-duplicates are sent once, and the same seed always gives
+// the same sample.
+func sampleCommands(commands []string, patterns analysis.CommandPattern, budget int, count tokenCounter, seed int64) []string {
+	// One giant pasted command shouldn't eat the whole budget
+	maxCommandTokens := budget / 20
+	if maxCommandTokens < minCommandTokens {
+		maxCommandTokens = minCommandTokens
+	}
+
+	entries, byShape := dedupeCommands(commands, maxCommandTokens, count)
+
+	total := 0
+	for _, e := range entries {
+		total += e.cost
+	}
+	if total <= budget {
+		// Everything fits, so use everything
+		return sampleText(entries, allIndexes(len(entries)))
+	}
+
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	var picked []int
+	used := make([]bool, len(entries))
+	taken := make(map[string]int)
+	remaining := budget
+
+	pick := func(i int) bool {
+		if used[i] || entries[i].cost > remaining {
+			return false
+		}
+		used[i] = true
+		remaining -= entries[i].cost
+		taken[entries[i].family]++
+		picked = append(picked, i)
+		return true
+	}
+
+	// 1. The commands the findings are about, most recent first, taking turns
+	// between kinds of finding so one long list can't crowd out the others
+	findingBudget := int(float64(budget) * findingShare)
+	for _, i := range findingCommands(entries, byShape, patterns) {
+		if budget-remaining+entries[i].cost > findingBudget {
+			continue
+		}
+		pick(i)
+	}
+
+	// 2. A proportional spread over clusters of similar commands
+	clusters := clusterEntries(entries, rng)
+	for len(clusters) > 0 {
+		// Highest average: the cluster that is most under-represented so far
+		best, bestScore := -1, -1.0
+		for ci, c := range clusters {
+			score := float64(c.count) / float64(taken[c.family]+1)
+			if score > bestScore {
+				best, bestScore = ci, score
+			}
+		}
+
+		c := &clusters[best]
+		added := false
+		for len(c.members) > 0 && !added {
+			added = pick(c.members[0])
+			c.members = c.members[1:]
+		}
+		if len(c.members) == 0 {
+			clusters = append(clusters[:best], clusters[best+1:]...)
+		}
+	}
+
+	return sampleText(entries, picked)
+}
+
+// dedupeCommands collapses near-duplicate commands into one entry each, in
+// order of first appearance, and indexes them by shape
+func dedupeCommands(commands []string, maxCommandTokens int, count tokenCounter) ([]sampleEntry, map[string]int) {
+	var entries []sampleEntry
+	var firsts []string
+	byShape := make(map[string]int)
+
+	for i, cmd := range commands {
+		if strings.TrimSpace(cmd) == "" {
+			continue
+		}
+
+		shape := commandShape(cmd)
+		idx, ok := byShape[shape]
+		if !ok {
+			idx = len(entries)
+			byShape[shape] = idx
+			entries = append(entries, sampleEntry{})
+			firsts = append(firsts, cmd)
+		}
+
+		e := &entries[idx]
+		e.count++
+		e.last = i
+		e.text = cmd
+	}
+
+	// Clusters go by the first variant seen, parsed once per entry
+	for i, line := range shell.ParseAll(firsts) {
+		if first, ok := line.First(); ok {
+			entries[i].program = first.Name
+			entries[i].family = commandFamily(first)
+		}
+	}
+
+	for i := range entries {
+		entries[i].text = truncateToTokens(entries[i].text, maxCommandTokens, count)
+		// Each command also costs the "- " and newline around it in the prompt
+		entries[i].cost = count(entries[i].text) + 2
+	}
+
+	return entries, byShape
+}
+
+// commandShape reduces a command to what's left once numbers, hashes, UUIDs
+// and spacing are ignored, so reruns with a different port or commit compare equal
+func commandShape(cmd string) string {
+	shape := strings.Join(strings.Fields(strings.ToLower(cmd)), " ")
+	shape = uuidRe.ReplaceAllString(shape, "0")
+	return wordRe.ReplaceAllStringFunc(shape, func(word string) string {
+		if isVariable(word) {
+			return "0"
+		}
+		return word
+	})
+}
+
+// isVariable reports whether a lowercase word is a number, like a port, PID or
+// version part, or a hex hash, like a commit or container ID. Names that merely
+// contain a digit, like python3 or h264, are part of what the command is.
+func isVariable(word string) bool {
+	digits := 0
+	for _, c := range word {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c < 'a' || c > 'f':
+			return false
+		}
+	}
+	return digits == len(word) || (digits > 0 && len(word) >= minHashLength)
+}
+
+// commandFamily names the cluster a command belongs to: its program, plus the
+// subcommand for tools that have them
+func commandFamily(c shell.Command) string {
+	if c.Subcommand != "" {
+		return c.Name + " " + c.Subcommand
+	}
+	return c.Name
+}
+
+// findingCommands returns the entries behind the pattern findings, taking one
+// from each kind of finding in turn, most recent first
+func findingCommands(entries []sampleEntry, byShape map[string]int, patterns analysis.CommandPattern) []int {
+	lookup := func(commands []string) []int {
+		var found []int
+		for i := len(commands) - 1; i >= 0; i-- {
+			if idx, ok := byShape[commandShape(commands[i])]; ok {
+				found = append(found, idx)
+			}
+		}
+		return found
+	}
+
+	// A repeated command is a program name, so show its most common use
+	repeated := append([]analysis.CommandCount(nil), patterns.RepeatedCommands...)
+	sort.SliceStable(repeated, func(i, j int) bool { return repeated[i].Count > repeated[j].Count })
+	var repeatedFound []int
+	for _, r := range repeated {
+		best := -1
+		for i, e := range entries {
+			if e.program == r.Command {
+				if best < 0 || e.count > entries[best].count {
+					best = i
+				}
+			}
+		}
+		if best >= 0 {
+			repeatedFound = append(repeatedFound, best)
+		}
+	}
+
+	// Tool findings are listed most frequent first, which lookup reverses
+	var toolExamples []string
+	for i := len(patterns.ToolFindings) - 1; i >= 0; i-- {
+		toolExamples = append(toolExamples, patterns.ToolFindings[i].Example)
+	}
+
+	kinds := [][]int{lookup(patterns.FailedCommands), lookup(patterns.ComplexCommands), repeatedFound, lookup(toolExamples)}
+
+	var found []int
+	for round := 0; ; round++ {
+		more := false
+		for _, kind := range kinds {
+			if round < len(kind) {
+				found = append(found, kind[round])
+				more = true
+			}
+		}
+		if !more {
+			return found
+		}
+	}
+}
+
+// sampleCluster is a group of similar commands, ordered by which to take first
+type sampleCluster struct {
+	family  string
+	count   int
+	members []int
+}
+
+// clusterEntries groups entries by family, biggest first. Within a cluster,
+// commands run more often tend to come first, with the seed deciding the rest.
+func clusterEntries(entries []sampleEntry, rng *rand.Rand) []sampleCluster {
+	byFamily := make(map[string]int)
+	var clusters []sampleCluster
+	for i, e := range entries {
+		ci, ok := byFamily[e.family]
+		if !ok {
+			ci = len(clusters)
+			byFamily[e.family] = ci
+			clusters = append(clusters, sampleCluster{family: e.family})
+		}
+		clusters[ci].count += e.count
+		clusters[ci].members = append(clusters[ci].members, i)
+	}
+
+	for ci := range clusters {
+		members := clusters[ci].members
+		// Weighted random order: each command's key is an exponential draw
+		// scaled by how often it was run, and the smallest keys go first
+		keys := make(map[int]float64, len(members))
+		for _, i := range members {
+			keys[i] = -math.Log(1-rng.Float64()) / float64(entries[i].count)
+		}
+		sort.Slice(members, func(a, b int) bool { return keys[members[a]] < keys[members[b]] })
+	}
+
+	sort.SliceStable(clusters, func(a, b int) bool { return clusters[a].count > clusters[b].count })
+	return clusters
+}
+
+// sampleText returns the text of the picked entries in history order
+func sampleText(entries []sampleEntry, picked []int) []string {
+	sort.Slice(picked, func(a, b int) bool { return entries[picked[a]].last < entries[picked[b]].last })
+
+	sampled := make([]string, len(picked))
+	for i, idx := range picked {
+		sampled[i] = entries[idx].text
+	}
+	return sampled
+}
+
+// allIndexes returns 0 through n-1
+func allIndexes(n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
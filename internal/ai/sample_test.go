@@ -0,0 +1,114 @@
+package ai
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+)
+
+func TestCommandShape(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"curl localhost:8080/health", "curl localhost:3000/health", true},
+		{"kill -9 4242", "kill -9 1337", true},
+		{"git show 3f2a9c1", "git show 9e8d7c6b5a", true},
+		{"docker logs 4f9c2a1b7e3d", "docker logs 0a1b2c3d4e5f", true},
+		{"kubectl get job 123e4567-e89b-12d3-a456-426614174000", "kubectl get job 9b2c7a1e-0f3d-4c5b-8a9e-1d2c3b4a5f6e", true},
+		{"pip install requests==2.31.0", "pip install requests==2.32.3", true},
+		{"ls   -la", "LS -la", true},
+		{"python3 app.py", "python2 app.py", false},
+		{"ffmpeg -c:v h264 out.mp4", "ffmpeg -c:v h265 out.mp4", false},
+		{"vim file1.txt", "vim file2.txt", false},
+		{"git checkout facade", "git checkout decade", false},
+		{"cd k8s", "cd k3s", false},
+	}
+
+	for _, tt := range tests {
+		if same := commandShape(tt.a) == commandShape(tt.b); same != tt.same {
+			t.Errorf("commandShape(%q) == commandShape(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
+
+// sampleHistory is a history too big for a small budget, with lots of
+// near-duplicates and a few rare commands
+func sampleHistory() []string {
+	var commands []string
+	for i := 0; i < 200; i++ {
+		commands = append(commands,
+			fmt.Sprintf("git commit -m 'wip %d'", i),
+			fmt.Sprintf("curl localhost:%d/health", 3000+i),
+			fmt.Sprintf("ls src/module%d", i%40),
+		)
+		if i%50 == 0 {
+			commands = append(commands, fmt.Sprintf("kubectl logs web-%d", i))
+		}
+	}
+	return append(commands, "rm -rf /tmp/build && make && make install", "gti status")
+}
+
+func TestSampleCommandsKeepsFindings(t *testing.T) {
+	commands := sampleHistory()
+	patterns := analysis.CommandPattern{
+		FailedCommands:  []string{"gti status"},
+		ComplexCommands: []string{"rm -rf /tmp/build && make && make install"},
+	}
+
+	for seed := int64(1); seed <= 20; seed++ {
+		sample := sampleCommands(commands, patterns, 200, estimateTokens, seed)
+		if len(sample) >= len(commands) {
+			t.Fatalf("seed %d: sampled all %d commands, want the budget to force a sample", seed, len(sample))
+		}
+		for _, finding := range append(patterns.FailedCommands, patterns.ComplexCommands...) {
+			if !slices.Contains(sample, finding) {
+				t.Errorf("seed %d: sample is missing finding %q", seed, finding)
+			}
+		}
+	}
+}
+
+func TestSampleCommandsNoDuplicates(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		sample := sampleCommands(sampleHistory(), analysis.CommandPattern{}, 300, estimateTokens, seed)
+
+		shapes := make(map[string]string)
+		for _, cmd := range sample {
+			if prev, ok := shapes[commandShape(cmd)]; ok {
+				t.Errorf("seed %d: %q and %q are both in the sample", seed, prev, cmd)
+			}
+			shapes[commandShape(cmd)] = cmd
+		}
+	}
+}
+
+func TestSampleCommandsSeed(t *testing.T) {
+	commands := sampleHistory()
+	patterns := analysis.CommandPattern{FailedCommands: []string{"gti status"}}
+
+	first := sampleCommands(commands, patterns, 300, estimateTokens, 42)
+	for i := 0; i < 5; i++ {
+		if again := sampleCommands(commands, patterns, 300, estimateTokens, 42); !reflect.DeepEqual(again, first) {
+			t.Fatalf("seed 42 gave %q, then %q", first, again)
+		}
+	}
+
+	// Another seed is allowed to pick differently, but has to pick something
+	if other := sampleCommands(commands, patterns, 300, estimateTokens, 7); len(other) == 0 {
+		t.Error("seed 7 gave an empty sample")
+	}
+}
+
+func TestSampleCommandsEverythingFits(t *testing.T) {
+	commands := []string{"ls", "cd src", "ls", "make test"}
+	got := sampleCommands(commands, analysis.CommandPattern{}, 1000, estimateTokens, 1)
+
+	// Exact repeats are sent once, at their most recent position
+	if want := []string{"cd src", "ls", "make test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
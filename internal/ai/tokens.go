@@ -0,0 +1,337 @@
+package ai
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/jasonlovesdoggo/roastme/internal/analysis"
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// defaultContextSize is assumed for models that aren't in the table
+const defaultContextSize = 4096
+
+// ollamaContextSize is what Ollama gives a model unless num_ctx is raised,
+// whatever the model itself supports
+const ollamaContextSize = 2048
+
+// contextSizes maps model name prefixes to their context window in tokens.
+// The longest matching prefix wins. A provider's context_size setting overrides it.
+var contextSizes = map[string]int{
+	"gpt-3.5-turbo":   16385,
+	"gpt-4":           8192,
+	"gpt-4-32k":       32768,
+	"gpt-4-turbo":     128000,
+	"gpt-4o":          128000,
+	"gpt-4.1":         1047576,
+	"o1":              200000,
+	"o3":              200000,
+	"o4":              200000,
+	"claude-2":        100000,
+	"claude-3":        200000,
+	"claude-sonnet-4": 200000,
+	"claude-opus-4":   200000,
+	"gemini-pro":      32760,
+	"gemini-1.0-pro":  32760,
+	"gemini-1.5":      1048576,
+	"gemini-2":        1048576,
+	"llama3":          8192,
+	"llama3.1":        131072,
+	"llama3.2":        131072,
+	"mistral":         32768,
+	"qwen2.5":         32768,
+	"phi3":            4096,
+	"gemma2":          8192,
+	"deepseek-r1":     131072,
+	"codellama":       16384,
+	"mixtral":         32768,
+	"command-r":       131072,
+}
+
+// Token budgets for the commands in a prompt, so a model with a huge context
+// isn't sent the whole history just because it fits
+var commandBudgets = map[ComplexityLevel]int{
+	SimpleRoast:  1500,
+	NormalRoast:  3000,
+	ComplexRoast: 6000,
+	BrutalRoast:  10000,
+}
+
+// minCommandBudget keeps at least a handful of commands in tiny context windows
+const minCommandBudget = 256
+
+// minCommandTokens is the least a single command is cut down to
+const minCommandTokens = 64
+
+// maxPatternExamples caps how many commands each pattern finding lists in the prompt
+const maxPatternExamples = 20
+
+// contextMargin is the share of the context window kept free, because token
+// counts for models without a real tokenizer are estimates
+const contextMargin = 0.1
+
+// contextSize returns the context window of the selected provider's model
+func contextSize(cfg config.Config) int {
+	settings := providerConfig(cfg, cfg.AI.Provider)
+	if settings.ContextSize > 0 {
+		return settings.ContextSize
+	}
+	if cfg.AI.Provider == "ollama" {
+		return ollamaContextSize
+	}
+
+	model := strings.ToLower(settings.Model)
+	// Strip tags and vendor prefixes such as "llama3.2:3b" or "models/gemini-pro"
+	model = model[strings.LastIndex(model, "/")+1:]
+	if i := strings.Index(model, ":"); i >= 0 {
+		model = model[:i]
+	}
+
+	best, size := 0, defaultContextSize
+	for prefix, n := range contextSizes {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, size = len(prefix), n
+		}
+	}
+	return size
+}
+
+// commandBudget returns how many tokens the sampled commands may use: the
+// complexity's target, capped so the prompt, its fixed text and the reply all
+// fit in the model's context window
+func commandBudget(cfg config.Config, complexity ComplexityLevel, maxTokens int, overhead int) int {
+	budget, ok := commandBudgets[complexity]
+	if !ok {
+		budget = commandBudgets[NormalRoast]
+	}
+
+	size := contextSize(cfg)
+	available := size - int(float64(size)*contextMargin) - maxTokens - overhead
+	if available < budget {
+		budget = available
+	}
+	if budget < minCommandBudget {
+		budget = minCommandBudget
+	}
+	return budget
+}
+
+// tokenCounter counts the tokens in a piece of text for a particular model
+type tokenCounter func(text string) int
+
+// encodings caches tiktoken encodings by model. A nil entry means the model
+// has no encoding, or it couldn't be loaded, so estimates are used instead.
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*tiktoken.Tiktoken)
+)
+
+// newTokenCounter returns a counter for the selected provider's model. OpenAI
+// models are counted exactly with tiktoken; other providers use their own
+// tokenizers, which we don't have, so their counts are estimated.
+func newTokenCounter(cfg config.Config) tokenCounter {
+	if cfg.AI.Provider == "openai" {
+		if enc := openAIEncoding(cfg.AI.OpenAI.Model); enc != nil {
+			return func(text string) int {
+				return len(enc.Encode(text, nil, nil))
+			}
+		}
+	}
+	return estimateTokens
+}
+
+// openAIEncoding returns the tiktoken encoding for an OpenAI model, or nil if
+// it isn't known or its encoding file isn't on this machine
+func openAIEncoding(model string) *tiktoken.Tiktoken {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if enc, ok := encodings[model]; ok {
+		return enc
+	}
+
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		enc = nil
+	}
+	encodings[model] = enc
+	return enc
+}
+
+func init() {
+	// tiktoken would otherwise download encodings on first use, with no
+	// timeout, and counting tokens must work offline for --dry-run
+	tiktoken.SetBpeLoader(localBpeLoader{})
+}
+
+// encodingHashes are the SHA-256 sums of the encoding files OpenAI publishes,
+// the same ones tiktoken checks its downloads against
+var encodingHashes = map[string]string{
+	"cl100k_base.tiktoken": "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	"o200k_base.tiktoken":  "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+	"p50k_base.tiktoken":   "94b5ca7dff4d00767bc256fdd1b27e5b17361d7b8a5f968547f9f23eb70d2069",
+	"r50k_base.tiktoken":   "306cd27f03c1a714eca7108e03d66b7dc042abe8c258b44c199a7ed9838dd930",
+}
+
+// localBpeLoader loads tiktoken encodings from the user's own cache directory,
+// never from the network or a shared directory such as /tmp. Files whose
+// contents don't match the published hash are rejected, and encodings that
+// aren't there fail to load, so their tokens are estimated.
+type localBpeLoader struct{}
+
+func (localBpeLoader) LoadTiktokenBpe(file string) (map[string]int, error) {
+	name := path.Base(file)
+	want, ok := encodingHashes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %s", file)
+	}
+
+	dir, err := encodingsDir()
+	if err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(dir, name)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s isn't available: %v", name, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+		return nil, fmt.Errorf("%s doesn't match the published %s", filename, name)
+	}
+
+	ranks := make(map[string]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed encoding in %s", filename)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("malformed encoding in %s: %v", filename, err)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("malformed encoding in %s: %v", filename, err)
+		}
+		ranks[string(decoded)] = n
+	}
+	return ranks, nil
+}
+
+// encodingsDir returns where tiktoken encoding files are looked for
+func encodingsDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "roastme", "tiktoken"), nil
+}
+
+// estimateTokens approximates a token count. Shell commands are full of
+// punctuation and paths, which tokenize worse than prose, so this assumes
+// about three characters per token rather than the usual four.
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (utf8.RuneCountInString(text) + 2) / 3
+}
+
+// truncateToTokens shortens text to roughly limit tokens, marking the cut
+func truncateToTokens(text string, limit int, count tokenCounter) string {
+	tokens := count(text)
+	if tokens <= limit {
+		return text
+	}
+
+	runes := []rune(text)
+	keep := len(runes) * limit / tokens
+	return string(runes[:keep]) + "…"
+}
+
+// trimPatterns caps the commands listed under each pattern finding, which
+// otherwise grow with the history and sit outside the command budget
+func trimPatterns(patterns analysis.CommandPattern, count tokenCounter) analysis.CommandPattern {
+	// Keep the most recent commands, skipping near-duplicates
+	trim := func(commands []string) []string {
+		seen := make(map[string]bool)
+		var trimmed []string
+		for i := len(commands) - 1; i >= 0 && len(trimmed) < maxPatternExamples; i-- {
+			shape := commandShape(commands[i])
+			if seen[shape] {
+				continue
+			}
+			seen[shape] = true
+			trimmed = append(trimmed, truncateToTokens(commands[i], minCommandTokens, count))
+		}
+		slices.Reverse(trimmed)
+		return trimmed
+	}
+
+	patterns.FailedCommands = trim(patterns.FailedCommands)
+	patterns.ComplexCommands = trim(patterns.ComplexCommands)
+
+	// Keep the most repeated ones
+	repeated := append([]analysis.CommandCount(nil), patterns.RepeatedCommands...)
+	sort.SliceStable(repeated, func(i, j int) bool { return repeated[i].Count > repeated[j].Count })
+	if len(repeated) > maxPatternExamples {
+		repeated = repeated[:maxPatternExamples]
+	}
+	patterns.RepeatedCommands = make([]analysis.CommandCount, len(repeated))
+	for i, r := range repeated {
+		r.Command = truncateToTokens(r.Command, minCommandTokens, count)
+		patterns.RepeatedCommands[i] = r
+	}
+
+	patterns.ToolFindings = append([]analysis.ToolFinding(nil), patterns.ToolFindings...)
+	for i := range patterns.ToolFindings {
+		patterns.ToolFindings[i].Example = truncateToTokens(patterns.ToolFindings[i].Example, minCommandTokens, count)
+	}
+
+	return patterns
+}
+
+// historyBudget returns how many tokens the earlier turns of a conversation may
+// use: at most half of what's left, so the new prompt still has room for commands
+func historyBudget(cfg config.Config, maxTokens int, overhead int) int {
+	size := contextSize(cfg)
+	available := size - int(float64(size)*contextMargin) - maxTokens - overhead
+	if available <= 0 {
+		return 0
+	}
+	return available / 2
+}
+
+// fitHistory drops the oldest turns of a conversation until the rest fit in the
+// budget, returning what's left and how many tokens it uses. Turns go in pairs,
+// so the history never starts with the model answering nothing.
+func fitHistory(history []llms.MessageContent, budget int, count tokenCounter) ([]llms.MessageContent, int) {
+	tokens := make([]int, len(history))
+	total := 0
+	for i, msg := range history {
+		tokens[i] = count(messageText(msg))
+		total += tokens[i]
+	}
+
+	for total > budget && len(history) >= 2 {
+		total -= tokens[0] + tokens[1]
+		history, tokens = history[2:], tokens[2:]
+	}
+	return history, total
+}
@@ -0,0 +1,105 @@
+package ai
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// cl100kURL is where tiktoken fetches the encoding GPT-4 uses
+const cl100kURL = "https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken"
+
+// fakeEncoding is a stand-in encoding with a token for every byte and no merges
+func fakeEncoding() []byte {
+	var ranks strings.Builder
+	for b := 0; b < 256; b++ {
+		fmt.Fprintf(&ranks, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b)
+	}
+	return []byte(ranks.String())
+}
+
+// writeFile writes data to path, creating its directory
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAITokenCounterOffline(t *testing.T) {
+	var cfg config.Config
+	cfg.AI.Provider = "openai"
+	cfg.AI.OpenAI.Model = "gpt-4"
+	const text = "git push --force"
+
+	fake := fakeEncoding()
+	sum := sha256.Sum256(fake)
+	fakeHash := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, cacheDir string)
+		exact bool
+	}{
+		{
+			name:  "not available",
+			setup: func(*testing.T, string) {},
+		},
+		{
+			name: "planted in the shared temp directory",
+			setup: func(t *testing.T, _ string) {
+				tmp := t.TempDir()
+				t.Setenv("TMPDIR", tmp)
+				encodingHashes["cl100k_base.tiktoken"] = fakeHash
+				writeFile(t, filepath.Join(tmp, "data-gym-cache", fmt.Sprintf("%x", sha1.Sum([]byte(cl100kURL)))), fake)
+			},
+		},
+		{
+			name: "doesn't match the published hash",
+			setup: func(t *testing.T, cacheDir string) {
+				writeFile(t, filepath.Join(cacheDir, "roastme", "tiktoken", "cl100k_base.tiktoken"), fake)
+			},
+		},
+		{
+			name: "in the user cache directory",
+			setup: func(t *testing.T, cacheDir string) {
+				encodingHashes["cl100k_base.tiktoken"] = fakeHash
+				writeFile(t, filepath.Join(cacheDir, "roastme", "tiktoken", "cl100k_base.tiktoken"), fake)
+			},
+			exact: true,
+		},
+	}
+
+	published := encodingHashes["cl100k_base.tiktoken"]
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			t.Setenv("XDG_CACHE_HOME", cacheDir)
+			t.Cleanup(func() { encodingHashes["cl100k_base.tiktoken"] = published })
+			tt.setup(t, cacheDir)
+			encodings = make(map[string]*tiktoken.Tiktoken)
+
+			got, want := newTokenCounter(cfg)(text), estimateTokens(text)
+			if tt.exact {
+				want = len(text)
+			}
+			if got != want {
+				t.Errorf("count = %d, want %d (exact: %v)", got, want, tt.exact)
+			}
+		})
+	}
+
+	encodings = make(map[string]*tiktoken.Tiktoken)
+}
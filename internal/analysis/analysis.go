@@ -1,7 +1,13 @@
 package analysis
 
 import (
+	"fmt"
+	"sort"
 	"strings"
+	"unicode"
+
+	"github.com/jasonlovesdoggo/roastme/internal/history"
+	"github.com/jasonlovesdoggo/roastme/internal/shell"
 )
 
 // CommandPattern represents patterns found in command history
@@ -12,16 +18,74 @@ type CommandPattern struct {
 	Indecisive       bool
 	TimeWasters      []string
 	SkillLevel       string
+	Shells           map[string]int // Commands per shell, when history came from more than one
+	ToolFindings     []ToolFinding  // Habits with specific tools, most frequent first
 }
 
 // CommandCount represents a command and its frequency
 type CommandCount struct {
 	Command string
 	Count   int
+	Shells  []string // Shells the command was run in, when known
+}
+
+// String formats the count for prompts, e.g. "git (42x in zsh, fish)"
+func (c CommandCount) String() string {
+	if len(c.Shells) == 0 {
+		return fmt.Sprintf("%s (%dx)", c.Command, c.Count)
+	}
+	return fmt.Sprintf("%s (%dx in %s)", c.Command, c.Count, strings.Join(c.Shells, ", "))
 }
 
 // AnalyzeHistory analyzes command patterns in history
 func AnalyzeHistory(commands []string) CommandPattern {
+	entries := make([]history.CommandEntry, len(commands))
+	for i, cmd := range commands {
+		entries[i].Command = cmd
+	}
+	return AnalyzeEntries(entries)
+}
+
+// AnalyzeEntries analyzes command patterns in history entries. When the
+// history source records exit codes (atuin, mcfly), failed commands are taken
+// from the real exit status instead of being guessed from retried prefixes.
+func AnalyzeEntries(entries []history.CommandEntry) CommandPattern {
+	return NewAnalyzer().Update(entries)
+}
+
+// lineFindings is what the detectors found in one history entry on its own
+type lineFindings struct {
+	name      string   // Program the entry ran, "" if none
+	complex   bool     // Long, or full of pipes, chains or nesting
+	wandering bool     // Just cd or ls
+	advanced  bool     // Uses tools that take some skill
+	wasters   []string // Time-wasting sites it mentions
+}
+
+// timeWasters are the sites that count as wasting time
+var timeWasters = []string{"reddit", "youtube", "twitter", "facebook", "instagram"}
+
+// findLine runs the detectors that only need the entry itself
+func findLine(line shell.Line) lineFindings {
+	var f lineFindings
+	if first, ok := line.First(); ok {
+		f.name = first.Name
+		f.wandering = first.Name == "cd" || first.Name == "ls"
+	}
+	f.complex = line.Pipes > 2 || line.Chains > 2 || line.Depth > 1 || len(line.Text) > 80
+	f.advanced = advanced(line)
+
+	cmdLower := strings.ToLower(line.Text)
+	for _, waster := range timeWasters {
+		if strings.Contains(cmdLower, waster) {
+			f.wasters = append(f.wasters, waster)
+		}
+	}
+	return f
+}
+
+// patterns totals what was found in each entry into the patterns of the whole window
+func (a *Analyzer) patterns() CommandPattern {
 	patterns := CommandPattern{
 		RepeatedCommands: []CommandCount{},
 		FailedCommands:   []string{},
@@ -29,16 +93,15 @@ func AnalyzeHistory(commands []string) CommandPattern {
 		Indecisive:       false,
 		TimeWasters:      []string{},
 		SkillLevel:       "beginner", // Default
+		ToolFindings:     []ToolFinding{},
 	}
 
-	// Count command frequencies
+	// Count command frequencies, by the program that actually ran rather
+	// than sudo, a variable assignment or time
 	commandCounts := make(map[string]int)
-	for _, cmd := range commands {
-		// Extract base command
-		baseCmdParts := strings.Fields(cmd)
-		if len(baseCmdParts) > 0 {
-			baseCmd := baseCmdParts[0]
-			commandCounts[baseCmd]++
+	for _, f := range a.found {
+		if f.name != "" {
+			commandCounts[f.name]++
 		}
 	}
 
@@ -52,71 +115,198 @@ func AnalyzeHistory(commands []string) CommandPattern {
 		}
 	}
 
-	// Look for failed commands and corrections
-	for i := 1; i < len(commands); i++ {
-		prevCmd := commands[i-1]
-		currCmd := commands[i]
-
-		// Check for potential corrections
-		if (strings.HasPrefix(prevCmd, "cd ") ||
-			strings.HasPrefix(prevCmd, "mkdir ") ||
-			strings.HasPrefix(prevCmd, "git ")) &&
-			strings.HasPrefix(currCmd, prevCmd[:min(len(prevCmd), 4)]) {
-			patterns.FailedCommands = append(patterns.FailedCommands, prevCmd)
+	// Look for failed commands: ones that were retried straight away with a fix,
+	// unless the source recorded exit codes
+	hasExitCodes := false
+	failed := []string{}
+	for i, entry := range a.entries {
+		if a.retried[i] {
+			patterns.FailedCommands = append(patterns.FailedCommands, entry.Command)
+		}
+		if entry.ExitCode == nil {
+			continue
+		}
+		hasExitCodes = true
+		if *entry.ExitCode != 0 && !contains(failed, entry.Command) {
+			failed = append(failed, entry.Command)
 		}
 	}
 
-	// Check for complex commands
-	for _, cmd := range commands {
-		if strings.Count(cmd, "|") > 2 || strings.Count(cmd, ";") > 2 || len(cmd) > 80 {
-			patterns.ComplexCommands = append(patterns.ComplexCommands, cmd)
-		}
+	if hasExitCodes {
+		patterns.FailedCommands = failed
 	}
 
-	// Check for indecisiveness (lots of cd, ls in sequence)
+	// Check for complex commands, indecisiveness (lots of cd, ls in sequence),
+	// "time waster" commands and skill level
 	cdLsCount := 0
-	for _, cmd := range commands {
-		if strings.HasPrefix(cmd, "cd ") || strings.HasPrefix(cmd, "ls ") || cmd == "ls" {
+	advancedCount := 0
+	for i, f := range a.found {
+		if f.complex {
+			patterns.ComplexCommands = append(patterns.ComplexCommands, a.entries[i].Command)
+		}
+		if f.wandering {
 			cdLsCount++
 		}
+		if f.advanced {
+			advancedCount++
+		}
+		for _, waster := range f.wasters {
+			if !contains(patterns.TimeWasters, waster) {
+				patterns.TimeWasters = append(patterns.TimeWasters, waster)
+			}
+		}
 	}
 
-	if float64(cdLsCount) > float64(len(commands))*0.4 { // If more than 40% are cd/ls
+	if float64(cdLsCount) > float64(len(a.found))*0.4 { // If more than 40% are cd/ls
 		patterns.Indecisive = true
 	}
 
-	// Look for "time waster" commands
-	timeWasters := []string{"reddit", "youtube", "twitter", "facebook", "instagram"}
-	for _, cmd := range commands {
-		cmdLower := strings.ToLower(cmd)
-		for _, waster := range timeWasters {
-			if strings.Contains(cmdLower, waster) && !contains(patterns.TimeWasters, waster) {
-				patterns.TimeWasters = append(patterns.TimeWasters, waster)
+	// Guess skill level based on command complexity
+	if advancedCount > 5 {
+		patterns.SkillLevel = "advanced"
+	} else if advancedCount > 2 {
+		patterns.SkillLevel = "intermediate"
+	}
+
+	// Look for habits with specific tools
+	patterns.ToolFindings = toolFindings(a.runs, a.kinds)
+
+	// Attribute habits to shells when the history was merged from several
+	shells := make(map[string]int)
+	commandShells := make(map[string]map[string]bool)
+	for i, entry := range a.entries {
+		if entry.Shell == "" {
+			continue
+		}
+		shells[entry.Shell]++
+
+		if name := a.found[i].name; name != "" {
+			if commandShells[name] == nil {
+				commandShells[name] = make(map[string]bool)
 			}
+			commandShells[name][entry.Shell] = true
 		}
 	}
 
-	// Guess skill level based on command complexity
-	advancedCmds := []string{"awk", "sed", "grep -E", "xargs", "find -exec", "docker", "kubernetes", "k8s", "kubectl"}
-	advancedCount := 0
+	if len(shells) > 1 {
+		patterns.Shells = shells
+		for i, repeated := range patterns.RepeatedCommands {
+			for name := range commandShells[repeated.Command] {
+				patterns.RepeatedCommands[i].Shells = append(patterns.RepeatedCommands[i].Shells, name)
+			}
+			sort.Strings(patterns.RepeatedCommands[i].Shells)
+		}
+	}
+
+	return patterns
+}
+
+// advancedTools are programs that suggest someone knows their way around
+var advancedTools = []string{"awk", "gawk", "sed", "xargs", "docker", "kubectl", "helm", "k9s"}
+
+// advanced reports whether an entry uses any of the advanced tools anywhere,
+// including inside pipes and substitutions
+func advanced(line shell.Line) bool {
+	for _, c := range line.Commands {
+		switch {
+		case contains(advancedTools, c.Name):
+			return true
+		case c.Name == "grep" && c.Has("-E", "-P", "--extended-regexp", "--perl-regexp"):
+			return true
+		case c.Name == "find" && c.Has("-exec", "-execdir"):
+			return true
+		}
+	}
+	return false
+}
+
+// retryFlags are programs that fail without a flag people tend to forget,
+// like mkdir -p or rm -r
+var retryFlags = []string{"mkdir", "rm", "cp", "rmdir", "git", "ln"}
+
+// retried reports whether an entry looks like a fix for the one before it,
+// which means the earlier one most likely failed: a typo fixed, a missing flag
+// or sudo added, or a misspelt program name corrected
+func retried(prev, cur shell.Line) bool {
+	if prev.Text == cur.Text {
+		return false
+	}
+	p, ok := prev.First()
+	if !ok {
+		return false
+	}
+	c, ok := cur.First()
+	if !ok {
+		return false
+	}
+
+	prevArgs, curArgs := strings.Join(p.Args, " "), strings.Join(c.Args, " ")
+	if p.Name != c.Name {
+		// gti status, then git status
+		return prevArgs == curArgs && len(p.Name) > 2 && editDistance(p.Name, c.Name) <= 2
+	}
 
-	for _, cmd := range commands {
-		cmdLower := strings.ToLower(cmd)
-		for _, advCmd := range advancedCmds {
-			if strings.Contains(cmdLower, advCmd) {
-				advancedCount++
-				break
+	switch {
+	case prevArgs == curArgs:
+		// Permission denied, so again with sudo
+		return c.Sudo() && !p.Sudo()
+	case contains(retryFlags, p.Name) && len(c.Flags) > len(p.Flags) && equal(p.Operands(), c.Operands()):
+		// mkdir a/b, then mkdir -p a/b
+		return true
+	}
+
+	// git psuh, then git push: one argument off by a letter or two, and not
+	// just a different number like page=1 then page=2
+	if len(p.Args) != len(c.Args) {
+		return false
+	}
+	changed := -1
+	for i := range p.Args {
+		if p.Args[i] != c.Args[i] {
+			if changed >= 0 {
+				return false
 			}
+			changed = i
 		}
 	}
+	before, after := p.Args[changed], c.Args[changed]
+	return len(after) > 3 && editDistance(before, after) <= 2 && withoutDigits(before) != withoutDigits(after)
+}
 
-	if advancedCount > 5 {
-		patterns.SkillLevel = "advanced"
-	} else if advancedCount > 2 {
-		patterns.SkillLevel = "intermediate"
+// withoutDigits removes the digits from a string
+func withoutDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// editDistance counts the single-character edits between two strings. Anything
+// over 2 apart is reported as 3, since only near misses matter.
+func editDistance(a, b string) int {
+	if len(a)-len(b) > 2 || len(b)-len(a) > 2 || len(a) > 200 || len(b) > 200 {
+		return 3
 	}
 
-	return patterns
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return min(prev[len(b)], 3)
 }
 
 // Helper functions
@@ -127,6 +317,18 @@ func min(a, b int) int {
 	return b
 }
 
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
@@ -0,0 +1,125 @@
+package analysis
+
+import (
+	"github.com/jasonlovesdoggo/roastme/internal/history"
+	"github.com/jasonlovesdoggo/roastme/internal/shell"
+)
+
+// Analyzer keeps the analysis of a window of history up to date as commands
+// are appended to it. New entries are parsed and run through the detectors,
+// entries that scroll out of the window are dropped, and only the few entries
+// next to either end, which detectors compare with their neighbours, are
+// looked at again. Totalling up the findings doesn't parse anything.
+type Analyzer struct {
+	entries []history.CommandEntry
+	lines   []shell.Line
+	found   []lineFindings
+	retried []bool // Whether each entry was retried with a fix by the next one
+
+	runs    []toolRun  // Every simple command in the window, for the tool rules
+	runsPer []int      // How many runs each entry has
+	kinds   [][]string // Tool habits each run showed
+}
+
+// NewAnalyzer creates an analyzer that hasn't seen any history yet
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{}
+}
+
+// Update analyzes entries, the current window of history, reusing what was
+// found last time for the entries that are still in it. Anything other than
+// new entries at the end and old ones gone from the start is analyzed afresh.
+func (a *Analyzer) Update(entries []history.CommandEntry) CommandPattern {
+	a.drop(a.overlap(entries))
+	a.add(entries[len(a.entries):])
+	return a.patterns()
+}
+
+// overlap returns how many of the oldest entries analyzed last time have to
+// go for the rest to line up with the start of entries
+func (a *Analyzer) overlap(entries []history.CommandEntry) int {
+	for gone := 0; gone < len(a.entries); gone++ {
+		kept := a.entries[gone:]
+		if len(kept) <= len(entries) && sameEntries(kept, entries[:len(kept)]) {
+			return gone
+		}
+	}
+	return len(a.entries)
+}
+
+// drop forgets the oldest n entries
+func (a *Analyzer) drop(n int) {
+	if n == 0 {
+		return
+	}
+
+	runs := 0
+	for _, count := range a.runsPer[:n] {
+		runs += count
+	}
+
+	a.entries = a.entries[n:]
+	a.lines = a.lines[n:]
+	a.found = a.found[n:]
+	a.retried = a.retried[n:]
+	a.runsPer = a.runsPer[n:]
+	a.runs = a.runs[runs:]
+	a.kinds = a.kinds[runs:]
+
+	// The first runs left were compared with ones that are gone now
+	for i := 0; i < min(toolLookback, len(a.runs)); i++ {
+		a.kinds[i] = toolKinds(a.runs, i)
+	}
+}
+
+// add analyzes entries appended to the window
+func (a *Analyzer) add(entries []history.CommandEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	lines := shell.ParseAll(history.Commands(entries))
+
+	// The last entry so far can now be compared with the one after it
+	if n := len(a.lines); n > 0 {
+		a.retried[n-1] = retried(a.lines[n-1], lines[0])
+	}
+
+	before := len(a.runs)
+	for i, line := range lines {
+		a.entries = append(a.entries, entries[i])
+		a.lines = append(a.lines, line)
+		a.found = append(a.found, findLine(line))
+		a.retried = append(a.retried, i+1 < len(lines) && retried(line, lines[i+1]))
+
+		runs := toolRuns(line)
+		a.runs = append(a.runs, runs...)
+		a.runsPer = append(a.runsPer, len(runs))
+	}
+
+	// The last few runs so far can now look ahead at the new ones
+	from := max(before-toolLookahead, 0)
+	a.kinds = a.kinds[:from]
+	for i := from; i < len(a.runs); i++ {
+		a.kinds = append(a.kinds, toolKinds(a.runs, i))
+	}
+}
+
+// sameEntries reports whether two lists of entries are the same as far as
+// the analysis is concerned
+func sameEntries(a, b []history.CommandEntry) bool {
+	for i := range a {
+		if a[i].Command != b[i].Command || a[i].Shell != b[i].Shell || !sameExitCode(a[i].ExitCode, b[i].ExitCode) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameExitCode reports whether two optional exit codes are equal
+func sameExitCode(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
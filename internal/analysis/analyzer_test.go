@@ -0,0 +1,76 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/history"
+)
+
+// analyzerHistory has habits that span neighbouring entries, so a window
+// boundary falls in the middle of them somewhere
+var analyzerHistory = []string{
+	"gti status", "git status", "git status", "git status",
+	"mkdir a/b", "mkdir -p a/b",
+	"git add .", "git commit -m wip",
+	"npm install", "ls", "npm install", "npm install",
+	"kubectl get pods", "kubectl get pods", "kubectl get pods",
+	"make", "make", "make", "make clean", "make",
+	"cd ~/src", "ls", "cd ..", "ls -la",
+	"cat log | grep -E err | awk '{print $1}' | sort | uniq -c",
+	"sudo systemctl restart nginx", "sudo systemctl restart nginx",
+	"apt install x", "sudo apt install x",
+	"youtube-dl https://youtube.com/watch?v=1",
+	"ssh deploy@host", "ssh deploy@host", "ssh deploy@host",
+	"git push -f origin main", "sudo !!",
+}
+
+// sortedPatterns orders the parts of the patterns that come out of a map
+func sortedPatterns(p CommandPattern) CommandPattern {
+	sort.Slice(p.RepeatedCommands, func(i, j int) bool { return p.RepeatedCommands[i].Command < p.RepeatedCommands[j].Command })
+	return p
+}
+
+func TestAnalyzerMatchesFullAnalysis(t *testing.T) {
+	var entries []history.CommandEntry
+	for round := 0; round < 4; round++ {
+		for i, cmd := range analyzerHistory {
+			entry := history.CommandEntry{Command: cmd, Shell: []string{"zsh", "bash"}[(round+i)%2]}
+			if round == 3 && i%4 == 0 {
+				code := i % 3
+				entry.ExitCode = &code
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, window := range []int{5, 17, 40} {
+		for _, step := range []int{1, 3, 7} {
+			t.Run(fmt.Sprintf("window %d step %d", window, step), func(t *testing.T) {
+				a := NewAnalyzer()
+				for end := 1; end <= len(entries); end += step {
+					shown := entries[max(end-window, 0):end]
+					got := sortedPatterns(a.Update(shown))
+					want := sortedPatterns(NewAnalyzer().Update(shown))
+					if !reflect.DeepEqual(got, want) {
+						t.Fatalf("entries %d to %d: incremental analysis differs from a full one\ngot  %+v\nwant %+v", end-len(shown), end, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestAnalyzerRewrittenHistory(t *testing.T) {
+	a := NewAnalyzer()
+	a.Update([]history.CommandEntry{{Command: "git status"}, {Command: "gti status"}})
+
+	// Nothing lines up with what was seen before, so it starts over
+	entries := []history.CommandEntry{{Command: "gti status"}, {Command: "git status"}}
+	got := a.Update(entries)
+	if want := []string{"gti status"}; !reflect.DeepEqual(got.FailedCommands, want) {
+		t.Errorf("FailedCommands = %q, want %q", got.FailedCommands, want)
+	}
+}
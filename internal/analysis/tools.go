@@ -0,0 +1,432 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jasonlovesdoggo/roastme/internal/shell"
+)
+
+// ToolFinding is a habit with one tool, like force-pushing to main
+type ToolFinding struct {
+	Tool    string // Tool the habit is about, e.g. "git"
+	Kind    string // What was found, e.g. "git-force-push-main"
+	Summary string // What the user did, e.g. "force-pushed to main or master"
+	Count   int    // How many times it happened
+	Example string // The most recent history entry that showed it
+}
+
+// String formats the finding for prompts, e.g.
+// "git: force-pushed to main or master (3x), e.g. git push -f origin main"
+func (f ToolFinding) String() string {
+	return fmt.Sprintf("%s: %s (%dx), e.g. %s", f.Tool, f.Summary, f.Count, f.Example)
+}
+
+// toolRun is one simple command in the history, along with the entry it came from
+type toolRun struct {
+	shell.Command
+	command string // The whole history entry
+}
+
+// mentions reports whether any argument contains one of the given strings
+func (r toolRun) mentions(parts ...string) bool {
+	for _, arg := range r.Args {
+		for _, part := range parts {
+			if strings.Contains(arg, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mentionsWord reports whether any argument has one of the given words in it,
+// splitting arguments at anything that isn't a letter or digit. Unlike
+// mentions, "prod" is found in prod-eu but not in preprod.
+func (r toolRun) mentionsWord(words ...string) bool {
+	for _, arg := range r.Args {
+		for _, word := range strings.FieldsFunc(strings.ToLower(arg), func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+		}) {
+			if contains(words, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resource reports whether any argument names one of the given resource types,
+// on its own, in a comma-separated list or before a name, as in pods/web
+func (r toolRun) resource(types ...string) bool {
+	for _, arg := range r.Args {
+		kinds, _, _ := strings.Cut(arg, "/")
+		for _, kind := range strings.Split(kinds, ",") {
+			if contains(types, kind) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// same reports whether two runs are the same command with the same arguments
+func (r toolRun) same(other toolRun) bool {
+	return r.Name == other.Name && strings.Join(r.Args, " ") == strings.Join(other.Args, " ")
+}
+
+// toolRule spots one habit with a tool
+type toolRule struct {
+	tool     string
+	kind     string
+	summary  string
+	programs []string
+	min      int // How many times it has to happen to count, when once isn't telling
+	match    func(runs []toolRun, i int) bool
+}
+
+// lazyCommitMessages are commit messages that say nothing
+var lazyCommitMessages = map[string]bool{
+	"fix": true, "fixes": true, "fixed": true, "fix stuff": true, "fix bug": true, "wip": true,
+	"update": true, "updates": true, "changes": true, "stuff": true, "asdf": true, "test": true,
+	"tmp": true, "temp": true, "minor": true, "small fix": true, "oops": true, "again": true, ".": true,
+}
+
+// podNames are the ways kubectl lets you name pods
+var podNames = []string{"po", "pod", "pods"}
+
+// editors are the programs that count as a text editor
+var editors = []string{"vim", "vi", "nvim", "nano", "emacs"}
+
+// forcePush reports whether a run is a git push that overwrites the remote,
+// with a flag or a refspec starting with +
+func forcePush(r toolRun) bool {
+	if r.Subcommand != "push" {
+		return false
+	}
+	for _, operand := range r.Operands() {
+		if strings.HasPrefix(operand, "+") {
+			return true
+		}
+	}
+	return r.Has("-f", "--force", "--force-with-lease")
+}
+
+// pushesTo reports whether a git push updates one of the given branches on the
+// remote, as in "origin main", "+main" or "HEAD:refs/heads/main"
+func pushesTo(r toolRun, branches ...string) bool {
+	for _, operand := range r.Operands() {
+		ref := strings.TrimPrefix(operand, "+")
+		if _, dst, ok := strings.Cut(ref, ":"); ok {
+			ref = dst
+		}
+		if contains(branches, strings.TrimPrefix(ref, "refs/heads/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// rerun reports whether the same command ran within the few runs before
+func rerun(runs []toolRun, i, window int) bool {
+	for j := i - 1; j >= 0 && j >= i-window; j-- {
+		if runs[j].same(runs[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// commitMessage returns the message given to git commit with -m
+func commitMessage(r toolRun) string {
+	for i, arg := range r.Args {
+		if (arg == "-m" || (strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.HasSuffix(arg, "m"))) && i+1 < len(r.Args) {
+			return strings.TrimSpace(r.Args[i+1])
+		}
+	}
+	return ""
+}
+
+// toolRules are the habits the analyzers look for. For each tool, only the
+// first rule that matches a command counts.
+var toolRules = []toolRule{
+	// git
+	{tool: "git", kind: "git-force-push-main", summary: "force-pushed to main or master", programs: []string{"git"},
+		match: func(runs []toolRun, i int) bool {
+			return forcePush(runs[i]) && pushesTo(runs[i], "main", "master")
+		}},
+	{tool: "git", kind: "git-force-push", summary: "force-pushed", programs: []string{"git"},
+		match: func(runs []toolRun, i int) bool { return forcePush(runs[i]) }},
+	{tool: "git", kind: "git-add-all-commit", summary: "staged everything with git add . and committed straight away", programs: []string{"git"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "add" && runs[i].Has(".", "-A", "--all") &&
+				i+1 < len(runs) && runs[i+1].Name == "git" && runs[i+1].Subcommand == "commit"
+		}},
+	{tool: "git", kind: "git-lazy-commit", summary: "committed with messages like \"fix\" or \"wip\"", programs: []string{"git"},
+		match: func(runs []toolRun, i int) bool {
+			if runs[i].Subcommand != "commit" {
+				return false
+			}
+			message := strings.ToLower(commitMessage(runs[i]))
+			return lazyCommitMessages[message] || (message != "" && len(message) < 4)
+		}},
+	{tool: "git", kind: "git-reset-hard", summary: "threw work away with git reset --hard", programs: []string{"git"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "reset" && runs[i].Has("--hard") }},
+	{tool: "git", kind: "git-no-verify", summary: "skipped the hooks with --no-verify", programs: []string{"git"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Has("--no-verify", "-n") && runs[i].Subcommand == "commit"
+		}},
+	{tool: "git", kind: "git-status-spam", summary: "ran git status again without doing anything in between", programs: []string{"git"}, min: 3,
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "status" && i > 0 && runs[i-1].Name == "git" && runs[i-1].Subcommand == "status"
+		}},
+
+	// docker
+	{tool: "docker", kind: "docker-prune-all", summary: "panic-pruned everything with docker system prune -a", programs: []string{"docker", "podman"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Has("prune") && runs[i].Has("-a", "--all", "--volumes", "-af", "-fa")
+		}},
+	{tool: "docker", kind: "docker-remove-all", summary: "removed every container or image at once", programs: []string{"docker", "podman"},
+		match: func(runs []toolRun, i int) bool {
+			sub := runs[i].Subcommand
+			return (sub == "rm" || sub == "rmi" || sub == "kill" || sub == "stop") && runs[i].mentions("$(docker", "`docker", "$(podman")
+		}},
+	{tool: "docker", kind: "docker-privileged", summary: "ran containers with --privileged", programs: []string{"docker", "podman"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "run" && runs[i].Has("--privileged") }},
+	{tool: "docker", kind: "docker-sudo", summary: "ran docker with sudo", programs: []string{"docker"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Sudo() }},
+
+	// kubectl
+	{tool: "kubectl", kind: "kubectl-force-delete", summary: "force-deleted resources with --grace-period=0", programs: []string{"kubectl"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "delete" && runs[i].Has("--force", "--grace-period=0")
+		}},
+	{tool: "kubectl", kind: "kubectl-delete-pod", summary: "deleted pods to make problems go away", programs: []string{"kubectl"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "delete" && runs[i].resource(podNames...)
+		}},
+	{tool: "kubectl", kind: "kubectl-exec-shell", summary: "opened shells inside running pods", programs: []string{"kubectl"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "exec" && runs[i].Has("-it", "-ti", "-i") && runs[i].Has("sh", "bash", "/bin/sh", "/bin/bash", "ash")
+		}},
+	{tool: "kubectl", kind: "kubectl-prod-context", summary: "switched kubectl to a production context", programs: []string{"kubectl", "kubectx"},
+		match: func(runs []toolRun, i int) bool {
+			return (runs[i].Name == "kubectx" || runs[i].Has("use-context")) && runs[i].mentionsWord("prod", "production")
+		}},
+	{tool: "kubectl", kind: "kubectl-get-spam", summary: "watched pods by running get pods over and over", programs: []string{"kubectl"}, min: 3,
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "get" && runs[i].resource(podNames...) && !runs[i].Has("-w", "--watch") && rerun(runs, i, 3)
+		}},
+
+	// npm, yarn and pnpm
+	{tool: "npm", kind: "npm-nuke-modules", summary: "deleted node_modules to fix things", programs: []string{"rm"},
+		match: func(runs []toolRun, i int) bool { return runs[i].mentions("node_modules") }},
+	{tool: "npm", kind: "npm-audit-force", summary: "ran npm audit fix --force", programs: []string{"npm"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "audit" && runs[i].Has("--force") }},
+	{tool: "npm", kind: "npm-global-install", summary: "installed packages globally", programs: []string{"npm", "yarn", "pnpm"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Has("-g", "--global") || (runs[i].Subcommand == "global" && runs[i].Has("add"))
+		}},
+	{tool: "npm", kind: "npm-force-install", summary: "forced installs past peer dependency errors", programs: []string{"npm"},
+		match: func(runs []toolRun, i int) bool {
+			return (runs[i].Subcommand == "install" || runs[i].Subcommand == "i") && runs[i].Has("--force", "--legacy-peer-deps")
+		}},
+	{tool: "npm", kind: "npm-install-loop", summary: "reinstalled dependencies over and over", programs: []string{"npm", "yarn", "pnpm"}, min: 2,
+		match: func(runs []toolRun, i int) bool {
+			sub := runs[i].Subcommand
+			return (sub == "install" || sub == "i" || sub == "ci" || sub == "add" || (sub == "" && runs[i].Name == "yarn")) && rerun(runs, i, 5)
+		}},
+
+	// pip
+	{tool: "pip", kind: "pip-break-system", summary: "installed with --break-system-packages", programs: []string{"pip", "pip3"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Has("--break-system-packages") }},
+	{tool: "pip", kind: "pip-sudo", summary: "ran pip install with sudo", programs: []string{"pip", "pip3"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Sudo() && runs[i].Subcommand == "install" }},
+	{tool: "pip", kind: "pip-upgrade-pip", summary: "upgraded pip itself instead of the actual problem", programs: []string{"pip", "pip3"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "install" && runs[i].Has("-U", "--upgrade") && runs[i].Has("pip")
+		}},
+	{tool: "pip", kind: "pip-install-loop", summary: "reinstalled the same packages over and over", programs: []string{"pip", "pip3"}, min: 2,
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "install" && rerun(runs, i, 5) }},
+
+	// apt, pacman and brew
+	{tool: "apt", kind: "apt-fix-broken", summary: "fixed broken packages with apt --fix-broken", programs: []string{"apt", "apt-get"},
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Has("--fix-broken") || (runs[i].Subcommand == "install" && runs[i].Has("-f"))
+		}},
+	{tool: "apt", kind: "apt-purge-yes", summary: "removed packages with -y without looking", programs: []string{"apt", "apt-get"},
+		match: func(runs []toolRun, i int) bool {
+			sub := runs[i].Subcommand
+			return (sub == "remove" || sub == "purge" || sub == "autoremove") && runs[i].Has("-y", "--yes")
+		}},
+	{tool: "apt", kind: "apt-update-spam", summary: "refreshed the package lists again and again", programs: []string{"apt", "apt-get"}, min: 5,
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "update" }},
+	{tool: "pacman", kind: "pacman-partial-upgrade", summary: "did partial upgrades with pacman -Sy", programs: []string{"pacman"},
+		match: func(runs []toolRun, i int) bool {
+			for _, arg := range runs[i].Args {
+				if strings.HasPrefix(arg, "-S") && strings.Contains(arg, "y") && !strings.Contains(arg, "u") {
+					return true
+				}
+			}
+			return false
+		}},
+	{tool: "pacman", kind: "pacman-overwrite", summary: "overwrote files pacman refused to touch", programs: []string{"pacman"},
+		match: func(runs []toolRun, i int) bool { return runs[i].mentions("--overwrite", "--force") }},
+	{tool: "brew", kind: "brew-sudo", summary: "ran brew with sudo", programs: []string{"brew"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Sudo() }},
+	{tool: "brew", kind: "brew-doctor", summary: "asked brew doctor for help", programs: []string{"brew"}, min: 2,
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "doctor" }},
+	{tool: "brew", kind: "brew-update-spam", summary: "updated Homebrew again and again", programs: []string{"brew"}, min: 5,
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].Subcommand == "update" || runs[i].Subcommand == "upgrade"
+		}},
+
+	// ssh
+	{tool: "ssh", kind: "ssh-no-hostkey", summary: "turned off host key checking", programs: []string{"ssh", "scp"},
+		match: func(runs []toolRun, i int) bool { return runs[i].mentions("StrictHostKeyChecking=no") }},
+	{tool: "ssh", kind: "ssh-root", summary: "logged in as root", programs: []string{"ssh"},
+		match: func(runs []toolRun, i int) bool {
+			for j, arg := range runs[i].Args {
+				if strings.HasPrefix(arg, "root@") || (arg == "-l" && j+1 < len(runs[i].Args) && runs[i].Args[j+1] == "root") {
+					return true
+				}
+			}
+			return false
+		}},
+	{tool: "ssh", kind: "ssh-known-hosts", summary: "wiped host keys with ssh-keygen -R", programs: []string{"ssh-keygen"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Has("-R") }},
+	{tool: "ssh", kind: "ssh-reconnect", summary: "reconnected to the same host over and over", programs: []string{"ssh"}, min: 3,
+		match: func(runs []toolRun, i int) bool { return rerun(runs, i, 3) }},
+
+	// systemctl
+	{tool: "systemctl", kind: "systemctl-firewall-off", summary: "turned the firewall off", programs: []string{"systemctl", "ufw"},
+		match: func(runs []toolRun, i int) bool {
+			if runs[i].Name == "ufw" {
+				return runs[i].Subcommand == "disable"
+			}
+			sub := runs[i].Subcommand
+			return (sub == "stop" || sub == "disable" || sub == "mask") && runs[i].mentions("ufw", "firewalld", "iptables", "nftables")
+		}},
+	{tool: "systemctl", kind: "systemctl-restart-loop", summary: "restarted the same service until it worked", programs: []string{"systemctl"}, min: 2,
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "restart" && rerun(runs, i, 5) }},
+	{tool: "systemctl", kind: "systemctl-daemon-reload", summary: "ran daemon-reload again and again", programs: []string{"systemctl"}, min: 3,
+		match: func(runs []toolRun, i int) bool { return runs[i].Subcommand == "daemon-reload" }},
+
+	// vim, nano and emacs
+	{tool: "editor", kind: "editor-quit-in-shell", summary: "typed vim commands into the shell", programs: []string{":q", ":q!", ":wq", ":wq!", ":x", ":qa", ":w"},
+		match: func(runs []toolRun, i int) bool { return true }},
+	{tool: "editor", kind: "editor-kill", summary: "killed the editor to get out of it", programs: []string{"kill", "killall", "pkill"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Has(editors...) }},
+	{tool: "editor", kind: "editor-sudo", summary: "edited files as root", programs: editors,
+		match: func(runs []toolRun, i int) bool { return runs[i].Sudo() }},
+	{tool: "editor", kind: "editor-config", summary: "kept tweaking the editor config", programs: editors, min: 3,
+		match: func(runs []toolRun, i int) bool {
+			return runs[i].mentions(".vimrc", "init.vim", "init.lua", ".emacs", "init.el", ".nanorc")
+		}},
+
+	// make
+	{tool: "make", kind: "make-sudo-install", summary: "ran sudo make install", programs: []string{"make"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Sudo() && runs[i].Has("install") }},
+	{tool: "make", kind: "make-clean-rebuild", summary: "ran make clean and tried again", programs: []string{"make"}, min: 2,
+		match: func(runs []toolRun, i int) bool { return runs[i].Has("clean") }},
+	{tool: "make", kind: "make-loop", summary: "ran make until it built", programs: []string{"make"}, min: 3,
+		match: func(runs []toolRun, i int) bool { return rerun(runs, i, 3) }},
+
+	// sudo and chmod
+	{tool: "sudo", kind: "sudo-bang-bang", summary: "reran the last command with sudo !!", programs: []string{"!!"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Sudo() }},
+	{tool: "sudo", kind: "sudo-root-shell", summary: "opened a root shell", programs: []string{"su", "sudo", "bash", "sh", "zsh"},
+		match: func(runs []toolRun, i int) bool {
+			// sudo -i runs nothing else, so it's its own program
+			return runs[i].Sudo() || (runs[i].Name == "sudo" && runs[i].Has("-i", "-s"))
+		}},
+	{tool: "chmod", kind: "chmod-777", summary: "made files world-writable with chmod 777", programs: []string{"chmod"},
+		match: func(runs []toolRun, i int) bool { return runs[i].Has("777", "a+rwx", "-R=777") }},
+}
+
+// ToolFindingKinds returns every kind of finding the tool analyzers can report
+func ToolFindingKinds() []string {
+	kinds := make([]string, 0, len(toolRules))
+	for _, rule := range toolRules {
+		if !contains(kinds, rule.kind) {
+			kinds = append(kinds, rule.kind)
+		}
+	}
+	return kinds
+}
+
+// Tool rules compare a run with at most toolLookback runs before it and
+// toolLookahead runs after it, so only runs that close to a change in the
+// history need matching again
+const (
+	toolLookback  = 5
+	toolLookahead = 1
+)
+
+// toolKinds returns the kinds of habit the i-th run shows. For each tool, only
+// the first rule that matches counts.
+func toolKinds(runs []toolRun, i int) []string {
+	var kinds []string
+	matched := make(map[string]bool)
+	for _, rule := range toolRules {
+		if matched[rule.tool] || !contains(rule.programs, runs[i].Name) || !rule.match(runs, i) {
+			continue
+		}
+		matched[rule.tool] = true
+		kinds = append(kinds, rule.kind)
+	}
+	return kinds
+}
+
+// toolFindings totals the habits each run showed, most frequent first
+func toolFindings(runs []toolRun, kindsPerRun [][]string) []ToolFinding {
+	rules := make(map[string]toolRule)
+	for _, rule := range toolRules {
+		rules[rule.kind] = rule
+	}
+
+	byKind := make(map[string]*ToolFinding)
+	var kinds []string
+	for i, run := range runs {
+		for _, kind := range kindsPerRun[i] {
+			f, ok := byKind[kind]
+			if !ok {
+				rule := rules[kind]
+				f = &ToolFinding{Tool: rule.tool, Kind: rule.kind, Summary: rule.summary}
+				byKind[kind] = f
+				kinds = append(kinds, kind)
+			}
+			f.Count++
+			f.Example = run.command
+		}
+	}
+
+	findings := []ToolFinding{}
+	for _, kind := range kinds {
+		if f := byKind[kind]; f.Count >= rules[kind].min {
+			findings = append(findings, *f)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Count > findings[j].Count })
+	return findings
+}
+
+// toolRuns lists every simple command in a history entry, including the ones
+// in pipelines, chains and substitutions
+func toolRuns(line shell.Line) []toolRun {
+	var runs []toolRun
+	for _, c := range line.Commands {
+		// python -m pip is pip
+		if (c.Name == "python" || c.Name == "python3") && len(c.Args) > 1 && c.Args[0] == "-m" && strings.HasPrefix(c.Args[1], "pip") {
+			c.Name, c.Args, c.Flags = c.Args[1], c.Args[2:], c.Flags[1:]
+			c.Subcommand = ""
+			if operands := c.Operands(); len(operands) > 0 {
+				c.Subcommand = operands[0]
+			}
+		}
+		runs = append(runs, toolRun{Command: c, command: line.Text})
+	}
+	return runs
+}
@@ -0,0 +1,110 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/history"
+)
+
+// toolRuleTests pair each kind of tool finding with a history that shows it
+// and a similar one that doesn't. Histories are separated by " ;; ", and the
+// first run of a repeated command never counts as a rerun.
+var toolRuleTests = []struct {
+	kind     string
+	positive string
+	negative string
+}{
+	{"git-force-push-main", "git push -f origin main", "git push -f origin feature/maintenance"},
+	{"git-force-push-main", "git push origin +HEAD:refs/heads/master", "git push origin +HEAD:release"},
+	{"git-force-push", "git push --force-with-lease origin feature", "git push origin feature"},
+	{"git-add-all-commit", "git add . ;; git commit -m 'parse flags'", "git add . ;; git diff --staged ;; git commit -m 'parse flags'"},
+	{"git-lazy-commit", "git commit -m wip", "git commit -m 'handle empty config files'"},
+	{"git-reset-hard", "git reset --hard HEAD~1", "git reset --soft HEAD~1"},
+	{"git-no-verify", "git commit --no-verify -m 'ship it now'", "git push --no-verify"},
+	{"git-status-spam", "git status ;; git status ;; git status ;; git status", "git status ;; git add . ;; git status"},
+	{"docker-prune-all", "docker system prune -af", "docker image prune"},
+	{"docker-remove-all", "docker rm -f $(docker ps -aq)", "docker rm web"},
+	{"docker-privileged", "docker run --privileged alpine", "docker run --rm alpine"},
+	{"docker-sudo", "sudo docker ps", "docker ps"},
+	{"kubectl-force-delete", "kubectl delete pod web --grace-period=0 --force", "kubectl get pod web"},
+	{"kubectl-delete-pod", "kubectl delete po web-1", "kubectl delete deployment web"},
+	{"kubectl-delete-pod", "kubectl delete pods/web-1", "kubectl delete -n pods-system deploy web"},
+	{"kubectl-delete-pod", "kubectl delete pod,svc -l app=web", "kubectl delete podsecuritypolicy restricted"},
+	{"kubectl-exec-shell", "kubectl exec -it web -- /bin/bash", "kubectl exec web -- ls"},
+	{"kubectl-prod-context", "kubectl config use-context prod-eu", "kubectl config use-context preprod"},
+	{"kubectl-prod-context", "kubectx gke_acme_us-east1_production", "kubectx nonprod"},
+	{"kubectl-get-spam", "kubectl get po ;; kubectl get po ;; kubectl get po ;; kubectl get po", "kubectl get deploy ;; kubectl get deploy ;; kubectl get deploy"},
+	{"kubectl-get-spam", "kubectl get pods ;; kubectl get pods ;; kubectl get pods ;; kubectl get pods", "kubectl get -n podinfo svc ;; kubectl get -n podinfo svc ;; kubectl get -n podinfo svc"},
+	{"npm-nuke-modules", "rm -rf node_modules", "rm -rf dist"},
+	{"npm-audit-force", "npm audit fix --force", "npm audit fix"},
+	{"npm-global-install", "npm install -g typescript", "npm install typescript"},
+	{"npm-force-install", "npm install --legacy-peer-deps", "npm install"},
+	{"npm-install-loop", "npm install ;; npm install ;; npm install", "npm install ;; npm test"},
+	{"pip-break-system", "pip install --break-system-packages requests", "pip install --user requests"},
+	{"pip-sudo", "sudo pip install requests", "pip install requests"},
+	{"pip-upgrade-pip", "python3 -m pip install --upgrade pip", "pip install --upgrade requests"},
+	{"pip-install-loop", "pip install requests ;; pip install requests ;; pip install requests", "pip install requests ;; pip freeze"},
+	{"apt-fix-broken", "sudo apt --fix-broken install", "sudo apt install curl"},
+	{"apt-purge-yes", "sudo apt purge -y nginx", "sudo apt purge nginx"},
+	{"apt-update-spam", "apt update ;; apt update ;; apt update ;; apt update ;; apt update", "apt update ;; apt update ;; apt update ;; apt update"},
+	{"pacman-partial-upgrade", "sudo pacman -Sy firefox", "sudo pacman -Syu"},
+	{"pacman-overwrite", "sudo pacman -S --overwrite '*' python-pip", "sudo pacman -S python-pip"},
+	{"brew-sudo", "sudo brew install wget", "brew install wget"},
+	{"brew-doctor", "brew doctor ;; brew doctor", "brew doctor"},
+	{"brew-update-spam", "brew update ;; brew upgrade ;; brew update ;; brew upgrade ;; brew update", "brew update ;; brew upgrade"},
+	{"ssh-no-hostkey", "ssh -o StrictHostKeyChecking=no deploy@web1", "ssh -o ServerAliveInterval=30 deploy@web1"},
+	{"ssh-root", "ssh root@web1", "ssh rooted@web1"},
+	{"ssh-known-hosts", "ssh-keygen -R web1", "ssh-keygen -t ed25519"},
+	{"ssh-reconnect", "ssh web1 ;; ssh web1 ;; ssh web1 ;; ssh web1", "ssh web1 ;; ssh web2 ;; ssh web3"},
+	{"systemctl-firewall-off", "sudo systemctl disable --now firewalld", "sudo systemctl restart nginx"},
+	{"systemctl-restart-loop", "systemctl restart nginx ;; systemctl restart nginx ;; systemctl restart nginx", "systemctl restart nginx ;; systemctl status nginx"},
+	{"systemctl-daemon-reload", "systemctl daemon-reload ;; systemctl daemon-reload ;; systemctl daemon-reload", "systemctl daemon-reload ;; systemctl daemon-reload"},
+	{"editor-quit-in-shell", ":wq", "vim main.go"},
+	{"editor-kill", "pkill vim", "pkill node"},
+	{"editor-sudo", "sudo nvim /etc/hosts", "nvim /etc/hosts"},
+	{"editor-config", "vim ~/.vimrc ;; vim ~/.vimrc ;; nvim ~/.config/nvim/init.lua", "vim main.go ;; vim main.go ;; vim main.go"},
+	{"make-sudo-install", "sudo make install", "make install"},
+	{"make-clean-rebuild", "make clean ;; make ;; make clean", "make clean ;; make"},
+	{"make-loop", "make ;; make ;; make ;; make", "make ;; make test ;; make lint"},
+	{"sudo-bang-bang", "sudo !!", "sudo ls"},
+	{"sudo-root-shell", "sudo -i", "sudo -u postgres psql"},
+	{"chmod-777", "chmod -R 777 /var/www", "chmod 755 /var/www"},
+}
+
+// toolKindsIn analyzes a history and returns the kinds of tool finding in it
+func toolKindsIn(commands string) []string {
+	var entries []history.CommandEntry
+	for _, cmd := range strings.Split(commands, " ;; ") {
+		entries = append(entries, history.CommandEntry{Command: cmd})
+	}
+
+	var kinds []string
+	for _, f := range NewAnalyzer().Update(entries).ToolFindings {
+		kinds = append(kinds, f.Kind)
+	}
+	return kinds
+}
+
+func TestToolRules(t *testing.T) {
+	for _, tt := range toolRuleTests {
+		t.Run(tt.kind, func(t *testing.T) {
+			if kinds := toolKindsIn(tt.positive); !contains(kinds, tt.kind) {
+				t.Errorf("%q: found %q, want %s", tt.positive, kinds, tt.kind)
+			}
+			if kinds := toolKindsIn(tt.negative); contains(kinds, tt.kind) {
+				t.Errorf("%q: found %s, want it left alone", tt.negative, tt.kind)
+			}
+		})
+	}
+
+	tested := make(map[string]bool)
+	for _, tt := range toolRuleTests {
+		tested[tt.kind] = true
+	}
+	for _, kind := range ToolFindingKinds() {
+		if !tested[kind] {
+			t.Errorf("no test for %s", kind)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
@@ -11,26 +13,89 @@ import (
 
 type Config struct {
 	AI struct {
-		Provider  string           `mapstructure:"provider"`
-		OpenAI    AIProviderConfig `mapstructure:"openai"`
-		Anthropic AIProviderConfig `mapstructure:"anthropic"`
-		Gemini    AIProviderConfig `mapstructure:"gemini"`
-		Custom    AIProviderConfig `mapstructure:"custom"`
+		// Provider is the provider in use, the first entry of Providers
+		Provider string `mapstructure:"-"`
+		// Providers is the ordered chain from ai.provider, which may be a single
+		// name or a list. Each one is tried in turn until one answers.
+		Providers  []string             `mapstructure:"provider"`
+		NoFallback bool                 `mapstructure:"no_fallback"`
+		Retries    int                  `mapstructure:"retries"`
+		Timeout    time.Duration        `mapstructure:"timeout"`
+		Stream     bool                 `mapstructure:"stream"`
+		Seed       int64                `mapstructure:"seed"`
+		Persona    string               `mapstructure:"persona"`
+		OpenAI     AIProviderConfig     `mapstructure:"openai"`
+		Anthropic  AIProviderConfig     `mapstructure:"anthropic"`
+		Gemini     AIProviderConfig     `mapstructure:"gemini"`
+		Custom     CustomProviderConfig `mapstructure:"custom"`
+		Ollama     AIProviderConfig     `mapstructure:"ollama"`
 	} `mapstructure:"ai"`
 	UI struct {
 		ColorTheme string `mapstructure:"colorTheme"`
 		Style      string `mapstructure:"style"`
 	} `mapstructure:"ui"`
+	History struct {
+		// DiskCache keeps parsed history under the user cache directory between
+		// runs. The cached commands are stored as-is, without redaction.
+		DiskCache bool `mapstructure:"disk_cache"`
+	} `mapstructure:"history"`
+	Redaction RedactionConfig `mapstructure:"redaction"`
+}
+
+// RedactionConfig controls how commands are scrubbed before being sent to an AI provider
+type RedactionConfig struct {
+	Enabled        bool            `mapstructure:"enabled"`
+	DisableEntropy bool            `mapstructure:"disable_entropy"`
+	Rules          []RedactionRule `mapstructure:"rules"`
+}
+
+// RedactionRule is a user-defined pattern whose matches are redacted
+type RedactionRule struct {
+	Name        string `mapstructure:"name"`
+	Pattern     string `mapstructure:"pattern"`
+	Replacement string `mapstructure:"replacement"`
 }
 
 type AIProviderConfig struct {
 	APIKey  string `mapstructure:"api_key"`
 	BaseURL string `mapstructure:"base_url"`
 	Model   string `mapstructure:"model"`
+	// Retries and Timeout override ai.retries and ai.timeout when set.
+	// Retries is a pointer so that retries = 0 turns retrying off.
+	Retries *int          `mapstructure:"retries"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	// ContextSize overrides the built-in context window size for the model, in tokens
+	ContextSize int `mapstructure:"context_size"`
+}
+
+// CustomProviderConfig configures a self-hosted or third-party endpoint.
+// In "openai" mode the base URL is an OpenAI-compatible API. In "http" mode it
+// is the full endpoint URL, the body is rendered from BodyTemplate and the
+// roast is read from the response at ResponsePath.
+type CustomProviderConfig struct {
+	AIProviderConfig `mapstructure:",squash"`
+	Mode             string            `mapstructure:"mode"`
+	BodyTemplate     string            `mapstructure:"body_template"`
+	ResponsePath     string            `mapstructure:"response_path"`
+	Headers          map[string]string `mapstructure:"headers"`
 }
 
 var config Config
 
+// Dir returns the directory for user files such as prompt templates,
+// $XDG_CONFIG_HOME/roastme or ~/.config/roastme
+func Dir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "roastme"), nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "roastme"), nil
+}
+
 func Init(cfgFile string) {
 	viper.SetConfigType("toml")
 	if cfgFile != "" {
@@ -40,7 +105,7 @@ func Init(cfgFile string) {
 		// Find home directory
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 
@@ -53,16 +118,26 @@ func Init(cfgFile string) {
 
 	// Set defaults - using Gemini as default provider
 	viper.SetDefault("ai.provider", "gemini")
+	viper.SetDefault("ai.no_fallback", false)
+	viper.SetDefault("ai.retries", 2)
+	viper.SetDefault("ai.timeout", "60s")
+	viper.SetDefault("ai.stream", true)
+	viper.SetDefault("ai.persona", "default")
 	viper.SetDefault("ai.openai.model", "gpt-3.5-turbo")
-	viper.SetDefault("ai.anthropic.model", "claude-2")
+	viper.SetDefault("ai.anthropic.model", "claude-3-5-haiku-latest")
 	viper.SetDefault("ai.gemini.model", "gemini-pro")
 	viper.SetDefault("ai.gemini.base_url", "https://generativelanguage.googleapis.com")
+	viper.SetDefault("ai.custom.mode", "openai")
+	viper.SetDefault("ai.ollama.base_url", "http://localhost:11434")
+	viper.SetDefault("ai.ollama.model", "llama3.2")
 	viper.SetDefault("ui.colorTheme", "dark")
 	viper.SetDefault("ui.style", "rounded")
+	viper.SetDefault("history.disk_cache", false)
+	viper.SetDefault("redaction.enabled", true)
 
 	// If a config file is found, read it in
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	} else {
 		// Create default config file if it doesn't exist
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -71,7 +146,12 @@ func Init(cfgFile string) {
 
 			// Create the default configuration with Gemini as default
 			defaultConfig := `[ai]
-provider = "gemini"
+provider = "gemini" # Or a fallback chain, e.g. ["ollama", "gemini", "local"]
+no_fallback = false
+retries = 2
+timeout = "60s"
+stream = true
+persona = "default"
 
 [ai.openai]
 api_key = ""
@@ -81,7 +161,7 @@ model = "gpt-3.5-turbo"
 [ai.anthropic]
 api_key = ""
 base_url = "https://api.anthropic.com"
-model = "claude-2"
+model = "claude-3-5-haiku-latest"
 
 [ai.gemini]
 api_key = ""
@@ -89,31 +169,75 @@ base_url = "https://generativelanguage.googleapis.com"
 model = "gemini-pro"
 
 [ai.custom]
+mode = "openai"
 api_key = ""
 base_url = ""
 model = ""
 
+[ai.ollama]
+base_url = "http://localhost:11434"
+model = "llama3.2"
+
 [ui]
 colorTheme = "dark"
 style = "rounded"
+
+[history]
+# Keeps a copy of your parsed history, unredacted, in your cache directory
+disk_cache = false
+
+[redaction]
+enabled = true
+disable_entropy = false
 `
 			// Write the default config to file
 			if err := os.WriteFile(configPath, []byte(defaultConfig), 0644); err != nil {
-				fmt.Println("Warning: Could not create default config file:", err)
+				fmt.Fprintln(os.Stderr, "Warning: Could not create default config file:", err)
 			} else {
-				fmt.Println("Created default config file:", configPath)
+				fmt.Fprintln(os.Stderr, "Created default config file:", configPath)
 				viper.SetConfigFile(configPath)
 				viper.ReadInConfig()
 			}
 		} else {
-			fmt.Println("Warning: Error reading config file:", err)
+			fmt.Fprintln(os.Stderr, "Warning: Error reading config file:", err)
 		}
 	}
 
 	// Unmarshal the config
 	if err := viper.Unmarshal(&config); err != nil {
-		fmt.Println("Unable to decode config:", err)
+		fmt.Fprintln(os.Stderr, "Unable to decode config:", err)
+	}
+	config.AI.Providers = normalizeProviders(config.AI.Providers)
+	if len(config.AI.Providers) > 0 {
+		config.AI.Provider = config.AI.Providers[0]
+	}
+}
+
+// normalizeProviders cleans up the provider chain, dropping blanks and duplicates
+func normalizeProviders(providers []string) []string {
+	var chain []string
+	seen := make(map[string]bool)
+	for _, p := range providers {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		chain = append(chain, p)
+	}
+	return chain
+}
+
+// providerSetting returns the value to save for ai.provider, keeping a single
+// provider as a plain string
+func providerSetting(chain []string) any {
+	switch len(chain) {
+	case 0:
+		return ""
+	case 1:
+		return chain[0]
 	}
+	return chain
 }
 
 func GetConfig() Config {
@@ -128,7 +252,10 @@ func UpdateConfig(newConfig Config) error {
 	config = newConfig
 
 	// Update viper values from the config struct
-	viper.Set("ai.provider", config.AI.Provider)
+	// A provider picked in the config UI moves to the front of an existing chain
+	config.AI.Providers = normalizeProviders(append([]string{config.AI.Provider}, config.AI.Providers...))
+	viper.Set("ai.provider", providerSetting(config.AI.Providers))
+	viper.Set("ai.no_fallback", config.AI.NoFallback)
 	viper.Set("ai.openai.api_key", config.AI.OpenAI.APIKey)
 	viper.Set("ai.openai.base_url", config.AI.OpenAI.BaseURL)
 	viper.Set("ai.openai.model", config.AI.OpenAI.Model)
@@ -141,8 +268,14 @@ func UpdateConfig(newConfig Config) error {
 	viper.Set("ai.custom.api_key", config.AI.Custom.APIKey)
 	viper.Set("ai.custom.base_url", config.AI.Custom.BaseURL)
 	viper.Set("ai.custom.model", config.AI.Custom.Model)
+	viper.Set("ai.custom.mode", config.AI.Custom.Mode)
+	viper.Set("ai.ollama.base_url", config.AI.Ollama.BaseURL)
+	viper.Set("ai.ollama.model", config.AI.Ollama.Model)
 	viper.Set("ui.colorTheme", config.UI.ColorTheme)
 	viper.Set("ui.style", config.UI.Style)
+	viper.Set("history.disk_cache", config.History.DiskCache)
+	viper.Set("redaction.enabled", config.Redaction.Enabled)
+	viper.Set("redaction.disable_entropy", config.Redaction.DisableEntropy)
 
 	return SaveConfig()
 }
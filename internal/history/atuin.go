@@ -0,0 +1,116 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseAtuinHistory reads atuin's history.db, which records the exit code,
+// duration, working directory, host and session of every command
+func parseAtuinHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	db, err := openSQLite(historyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Timestamps and durations are stored in nanoseconds; deleted rows are soft-deleted
+	rows, err := db.QueryContext(ctx, `SELECT command, timestamp, duration, exit, cwd, hostname, session
+		FROM history WHERE deleted_at IS NULL ORDER BY timestamp DESC LIMIT ?`, sqlLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("error querying atuin history: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []CommandEntry
+	for rows.Next() {
+		var cmd, cwd, hostname, session string
+		var timestamp, duration, exit int64
+		if err := rows.Scan(&cmd, &timestamp, &duration, &exit, &cwd, &hostname, &session); err != nil {
+			return nil, fmt.Errorf("error reading atuin history: %v", err)
+		}
+
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			continue
+		}
+
+		entry := CommandEntry{
+			Command:   cmd,
+			Timestamp: time.Unix(0, timestamp),
+			Cwd:       cwd,
+			Hostname:  hostname,
+			Session:   session,
+		}
+
+		// atuin uses -1 for commands that were still running when recorded
+		if exit >= 0 {
+			code := int(exit)
+			entry.ExitCode = &code
+		}
+		if duration > 0 {
+			entry.Duration = time.Duration(duration)
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading atuin history: %v", err)
+	}
+
+	reverseEntries(entries)
+	return entries, nil
+}
+
+// parseMcflyHistory reads McFly's history.db, which records the exit code,
+// working directory and session of every command
+func parseMcflyHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	db, err := openSQLite(historyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `SELECT cmd, COALESCE(when_run, 0), exit_code, COALESCE(dir, ''), COALESCE(session_id, '')
+		FROM commands ORDER BY id DESC LIMIT ?`, sqlLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("error querying mcfly history: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []CommandEntry
+	for rows.Next() {
+		var cmd, dir, session string
+		var whenRun int64
+		var exitCode *int64
+		if err := rows.Scan(&cmd, &whenRun, &exitCode, &dir, &session); err != nil {
+			return nil, fmt.Errorf("error reading mcfly history: %v", err)
+		}
+
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			continue
+		}
+
+		entry := CommandEntry{
+			Command:   cmd,
+			Timestamp: unixTime(whenRun),
+			Cwd:       dir,
+			Session:   session,
+		}
+		if exitCode != nil {
+			code := int(*exitCode)
+			entry.ExitCode = &code
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading mcfly history: %v", err)
+	}
+
+	reverseEntries(entries)
+	return entries, nil
+}
@@ -0,0 +1,99 @@
+package history
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// exitCodes reduces entries to their exit codes, with nil for unknown
+func exitCodes(entries []CommandEntry) []any {
+	out := make([]any, len(entries))
+	for i, e := range entries {
+		if e.ExitCode != nil {
+			out[i] = *e.ExitCode
+		}
+	}
+	return out
+}
+
+func TestParseAtuinHistory(t *testing.T) {
+	path := writeSQLite(t, t.TempDir(), "history.db",
+		`CREATE TABLE history (id TEXT PRIMARY KEY, timestamp INTEGER NOT NULL, duration INTEGER NOT NULL,
+			exit INTEGER NOT NULL, command TEXT NOT NULL, cwd TEXT NOT NULL, session TEXT NOT NULL,
+			hostname TEXT NOT NULL, deleted_at INTEGER)`,
+		// Timestamps and durations are in nanoseconds
+		`INSERT INTO history VALUES
+			('a', 1700000000000000000, 1500000000, 0, 'make test', '/src', 's1', 'box:me', NULL),
+			('b', 1700000010000000000, 0, 2, 'git pusj', '/src', 's1', 'box:me', NULL),
+			('c', 1700000020000000000, 0, 0, 'echo secret', '/src', 's1', 'box:me', 1700000030000000000),
+			('d', 1700000040000000000, -1, -1, 'npm run dev', '/web', 's2', 'box:me', NULL)`,
+	)
+
+	entries, err := parseAtuinHistory(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("parseAtuinHistory: %v", err)
+	}
+
+	// Deleted rows are left out
+	want := []parsed{
+		{"make test", 1700000000},
+		{"git pusj", 1700000010},
+		{"npm run dev", 1700000040},
+	}
+	if got := summarize(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Still-running commands are recorded with exit -1, which isn't a real exit code
+	if got, want := exitCodes(entries), []any{0, 2, nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exit codes = %v, want %v", got, want)
+	}
+	if entries[0].Duration != 1500*time.Millisecond || entries[2].Duration != 0 {
+		t.Errorf("durations = %v, %v, want 1.5s and 0", entries[0].Duration, entries[2].Duration)
+	}
+	if entries[2].Cwd != "/web" || entries[2].Session != "s2" || entries[2].Hostname != "box:me" {
+		t.Errorf("entry = %+v, want cwd, session and hostname filled in", entries[2])
+	}
+
+	limited, err := parseAtuinHistory(context.Background(), path, 1)
+	if err != nil {
+		t.Fatalf("parseAtuinHistory: %v", err)
+	}
+	if got, want := summarize(limited), []parsed{{"npm run dev", 1700000040}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("limited = %q, want %q", got, want)
+	}
+}
+
+func TestParseMcflyHistory(t *testing.T) {
+	path := writeSQLite(t, t.TempDir(), "history.db",
+		`CREATE TABLE commands (id INTEGER PRIMARY KEY AUTOINCREMENT, cmd TEXT NOT NULL,
+			when_run INTEGER, exit_code INTEGER, dir TEXT, session_id TEXT)`,
+		`INSERT INTO commands (cmd, when_run, exit_code, dir, session_id) VALUES
+			('ls', 1700000000, 0, '/home', 'x'),
+			('cargo biuld', 1700000010, 101, '/src', 'x'),
+			('  ', 1700000020, 0, '/src', 'x'),
+			('vim', NULL, NULL, NULL, NULL)`,
+	)
+
+	entries, err := parseMcflyHistory(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("parseMcflyHistory: %v", err)
+	}
+
+	want := []parsed{
+		{"ls", 1700000000},
+		{"cargo biuld", 1700000010},
+		{"vim", 0},
+	}
+	if got := summarize(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got, want := exitCodes(entries), []any{0, 101, nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exit codes = %v, want %v", got, want)
+	}
+	if entries[1].Cwd != "/src" || entries[1].Session != "x" {
+		t.Errorf("entry = %+v, want cwd and session filled in", entries[1])
+	}
+}
@@ -0,0 +1,246 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// cacheSignatureSize is how many bytes before the cached end of a file are
+// remembered, to check that a grown file was appended to rather than rewritten
+const cacheSignatureSize = 256
+
+// Cache remembers parsed history between reads, keyed on each file's path,
+// size and modification time. Unchanged files aren't parsed again, and files
+// that only had commands appended have just the new part parsed.
+type Cache struct {
+	mu         sync.Mutex
+	dir        string
+	files      map[string]*cachedFile
+	generation int
+}
+
+// cachedFile is the parsed state of a single history file
+type cachedFile struct {
+	Path      string         `json:"path"`
+	Size      int64          `json:"size"`
+	ModTime   time.Time      `json:"mod_time"`
+	Limit     int            `json:"limit"`
+	Signature []byte         `json:"signature"`
+	Entries   []CommandEntry `json:"entries"`
+}
+
+// activeCache is used by file-backed sources when set
+var activeCache *Cache
+
+// NewCache creates a history cache. If dir is not empty, parsed history is
+// also persisted there so it survives between runs.
+func NewCache(dir string) *Cache {
+	return &Cache{
+		dir:   dir,
+		files: make(map[string]*cachedFile),
+	}
+}
+
+// SetCache makes file-backed history sources read through the given cache.
+// Passing nil disables caching.
+func SetCache(c *Cache) {
+	activeCache = c
+}
+
+// DefaultCacheDir returns the on-disk history cache location under the user's cache directory
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "roastme", "history"), nil
+}
+
+// Generation returns a counter that changes whenever any cached history
+// changes, so callers can tell when derived results need recomputing
+func (c *Cache) Generation() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// read returns the entries of a history file, parsing only what changed since the last read
+func (c *Cache) read(ctx context.Context, file historyFile, limit int) ([]CommandEntry, error) {
+	size, modTime, cacheable := fileVersion(file.path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// We can't tell whether it changed, so always reparse and assume it did
+	if !cacheable {
+		c.generation++
+		return file.parse(ctx, file.path, limit)
+	}
+
+	cached := c.files[file.path]
+	if cached == nil {
+		cached = c.load(file.path)
+	}
+
+	if cached != nil && cached.Limit == limit {
+		// Nothing changed since the last read
+		if cached.Size == size && cached.ModTime.Equal(modTime) {
+			c.files[file.path] = cached
+			return copyEntries(cached.Entries), nil
+		}
+
+		// Only new commands were appended, so parse just those
+		if file.parseAppended != nil && size > cached.Size && c.appendedTo(file.path, cached) {
+			if entries, err := c.parseAppended(ctx, file, cached, size, limit); err == nil {
+				cached.Entries = entries
+				c.store(cached, size, modTime)
+				return copyEntries(entries), nil
+			}
+		}
+	}
+
+	entries, err := file.parse(ctx, file.path, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(&cachedFile{Path: file.path, Limit: limit, Entries: entries}, size, modTime)
+	return copyEntries(entries), nil
+}
+
+// appendedTo reports whether the bytes at the old end of the file are unchanged,
+// meaning the file was appended to rather than rewritten
+func (c *Cache) appendedTo(path string, cached *cachedFile) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	signature := make([]byte, len(cached.Signature))
+	if _, err := f.ReadAt(signature, cached.Size-int64(len(signature))); err != nil {
+		return false
+	}
+	return bytes.Equal(signature, cached.Signature)
+}
+
+// parseAppended parses the bytes added after the cached end of the file and
+// appends them to the cached entries, keeping the most recent limit
+func (c *Cache) parseAppended(ctx context.Context, file historyFile, cached *cachedFile, size int64, limit int) ([]CommandEntry, error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	appended, err := cancellable(ctx, file.parseAppended)(io.NewSectionReader(f, cached.Size, size-cached.Size), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return keepLast(append(copyEntries(cached.Entries), appended...), limit), nil
+}
+
+// store records a freshly parsed file in memory and, if enabled, on disk
+func (c *Cache) store(cached *cachedFile, size int64, modTime time.Time) {
+	cached.Size = size
+	cached.ModTime = modTime
+	cached.Signature = fileSignature(cached.Path, size)
+	c.files[cached.Path] = cached
+	c.generation++
+	c.save(cached)
+}
+
+// load reads a file's cached state from disk, if there is one
+func (c *Cache) load(path string) *cachedFile {
+	if c.dir == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.diskPath(path))
+	if err != nil {
+		return nil
+	}
+
+	var cached cachedFile
+	if err := json.Unmarshal(data, &cached); err != nil || cached.Path != path {
+		return nil
+	}
+	return &cached
+}
+
+// save persists a file's cached state to disk. Failures only cost us the
+// cache, so they are ignored.
+func (c *Cache) save(cached *cachedFile) {
+	if c.dir == "" {
+		return
+	}
+
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return
+	}
+	_ = os.WriteFile(c.diskPath(cached.Path), data, 0600)
+}
+
+// diskPath returns where a history file's cache is stored
+func (c *Cache) diskPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// fileVersion returns the size and modification time used to tell whether a
+// history file changed. SQLite databases in WAL mode record new commands in a
+// separate -wal file, so that is folded in too. Directories aren't cacheable
+// because files inside them can change without the directory changing.
+func fileVersion(path string) (int64, time.Time, bool) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return 0, time.Time{}, false
+	}
+
+	size, modTime := info.Size(), info.ModTime()
+	if wal, err := os.Stat(path + "-wal"); err == nil {
+		size += wal.Size()
+		if wal.ModTime().After(modTime) {
+			modTime = wal.ModTime()
+		}
+	}
+	return size, modTime, true
+}
+
+// fileSignature returns the last bytes before size, used to detect rewritten files
+func fileSignature(path string, size int64) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	n := int64(cacheSignatureSize)
+	if size < n {
+		n = size
+	}
+
+	signature := make([]byte, n)
+	if _, err := f.ReadAt(signature, size-n); err != nil {
+		return nil
+	}
+	return signature
+}
+
+// copyEntries returns a copy of entries so callers can't modify the cache
+func copyEntries(entries []CommandEntry) []CommandEntry {
+	return append([]CommandEntry(nil), entries...)
+}
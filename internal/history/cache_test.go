@@ -0,0 +1,161 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// countingFile wraps the zsh parsers, recording full parses and the bytes handed to the appended parser
+type countingFile struct {
+	fullParses int
+	appended   []string
+}
+
+func (c *countingFile) historyFile(path string) historyFile {
+	return historyFile{
+		path: path,
+		parse: func(ctx context.Context, path string, limit int) ([]CommandEntry, error) {
+			c.fullParses++
+			return parseZshHistory(ctx, path, limit)
+		},
+		parseAppended: func(r io.Reader, limit int) ([]CommandEntry, error) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			c.appended = append(c.appended, string(data))
+			return parseZshEntries(bytes.NewReader(data), limit)
+		},
+	}
+}
+
+// appendTo appends content to the file at path
+func appendTo(t *testing.T, path, content string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheParsesOnlyAppendedHistory(t *testing.T) {
+	path := writeTemp(t, t.TempDir(), ".zsh_history", ": 100:0;ls\n: 200:0;make\n")
+	counter := &countingFile{}
+	file := counter.historyFile(path)
+	c := NewCache("")
+	ctx := context.Background()
+
+	read := func() []string {
+		t.Helper()
+		entries, err := c.read(ctx, file, 0)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return Commands(entries)
+	}
+
+	if got, want := read(), []string{"ls", "make"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first read = %q, want %q", got, want)
+	}
+	generation := c.Generation()
+
+	// Unchanged files come straight from the cache
+	read()
+	if counter.fullParses != 1 || c.Generation() != generation {
+		t.Errorf("unchanged file: %d full parses, generation %d -> %d, want 1 parse and no change", counter.fullParses, generation, c.Generation())
+	}
+
+	// Appended commands are parsed on their own
+	appendTo(t, path, ": 300:0;git push\n")
+	if got, want := read(), []string{"ls", "make", "git push"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after append = %q, want %q", got, want)
+	}
+	if counter.fullParses != 1 {
+		t.Errorf("full parses = %d after an append, want 1", counter.fullParses)
+	}
+	if want := []string{": 300:0;git push\n"}; !reflect.DeepEqual(counter.appended, want) {
+		t.Errorf("appended parser got %q, want %q", counter.appended, want)
+	}
+	if c.Generation() == generation {
+		t.Error("generation didn't change after an append")
+	}
+	generation = c.Generation()
+
+	// A rewritten file, even a longer one, has to be parsed again
+	if err := os.WriteFile(path, []byte(": 150:0;vim\n: 250:0;make test\n: 350:0;git pull\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read(), []string{"vim", "make test", "git pull"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after rewrite = %q, want %q", got, want)
+	}
+	if counter.fullParses != 2 || c.Generation() == generation {
+		t.Errorf("rewritten file: %d full parses, generation %d -> %d, want 2 parses and a change", counter.fullParses, generation, c.Generation())
+	}
+}
+
+func TestCacheOnDisk(t *testing.T) {
+	path := writeTemp(t, t.TempDir(), ".zsh_history", ": 100:0;ls\n")
+	dir := t.TempDir()
+
+	counter := &countingFile{}
+	file := counter.historyFile(path)
+	if _, err := NewCache(dir).read(context.Background(), file, 0); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// A new run picks up where the last one left off
+	appendTo(t, path, ": 200:0;make\n")
+	entries, err := NewCache(dir).read(context.Background(), file, 0)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got, want := Commands(entries), []string{"ls", "make"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if counter.fullParses != 1 || len(counter.appended) != 1 {
+		t.Errorf("%d full parses and %d appended parses, want 1 and 1", counter.fullParses, len(counter.appended))
+	}
+}
+
+func TestCacheNoticesWAL(t *testing.T) {
+	path := writeTemp(t, t.TempDir(), "history.db", "database pages")
+	parses := 0
+	file := historyFile{
+		path: path,
+		parse: func(context.Context, string, int) ([]CommandEntry, error) {
+			parses++
+			return []CommandEntry{{Command: "ls"}}, nil
+		},
+	}
+
+	c := NewCache("")
+	for range 2 {
+		if _, err := c.read(context.Background(), file, 0); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+	if parses != 1 {
+		t.Fatalf("parses = %d before the WAL changed, want 1", parses)
+	}
+	generation := c.Generation()
+
+	// New commands land in the -wal file while the database itself is untouched
+	if err := os.WriteFile(path+"-wal", []byte("new commands"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.read(context.Background(), file, 0); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if parses != 2 || c.Generation() == generation {
+		t.Errorf("after a WAL write: %d parses, generation %d -> %d, want 2 parses and a change", parses, generation, c.Generation())
+	}
+}
@@ -0,0 +1,100 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseFishHistory parses fish shell history, which is stored in a YAML-like format.
+// Each entry starts with a "- cmd:" line followed by indented "when:" and
+// "paths:" keys, where paths holds a nested list of files the command touched.
+func parseFishHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	return readTail(ctx, historyFile, limit, parseFishEntries, fishEntryStart)
+}
+
+// parseFishEntries parses fish entries from r, keeping the most recent limit
+func parseFishEntries(r io.Reader, limit int) ([]CommandEntry, error) {
+	var entries []CommandEntry
+
+	var current *CommandEntry
+	inPaths := false
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if strings.TrimSpace(current.Command) != "" {
+			entries = append(entries, *current)
+		}
+		current = nil
+	}
+
+	scanner := newScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A new entry starts at column zero
+		if value, ok := strings.CutPrefix(line, "- cmd:"); ok {
+			flush()
+			current = &CommandEntry{Command: unescapeFish(strings.TrimPrefix(value, " "))}
+			inPaths = false
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inPaths && strings.HasPrefix(trimmed, "- "):
+			current.Paths = append(current.Paths, unescapeFish(strings.TrimPrefix(trimmed, "- ")))
+		case strings.HasPrefix(trimmed, "when:"):
+			inPaths = false
+			current.Timestamp = parseEpoch(strings.TrimPrefix(trimmed, "when:"))
+		case trimmed == "paths:":
+			inPaths = true
+		default:
+			// Unknown keys end any path list we were reading
+			inPaths = false
+		}
+	}
+	flush()
+
+	entries = keepLast(entries, limit)
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("error reading fish history file: %v", err)
+	}
+
+	return entries, nil
+}
+
+// unescapeFish decodes the escaping fish applies to history values:
+// newlines are written as "\n" and backslashes as "\\"
+func unescapeFish(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
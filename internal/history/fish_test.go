@@ -0,0 +1,53 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFishHistory(t *testing.T) {
+	entries, err := parseFishHistory(context.Background(), filepath.Join("testdata", "fish_history"), 0)
+	if err != nil {
+		t.Fatalf("parseFishHistory: %v", err)
+	}
+
+	want := []parsed{
+		{"ls -la", 1700000000},
+		{"echo first\nsecond", 1700000010},
+		{"vim ~/notes.txt ~/todo.txt", 1700000020},
+		{`echo back\slash`, 1700000030},
+		{"git status", 0},
+	}
+	if got := summarize(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	wantPaths := [][]string{nil, nil, {"~/notes.txt", "~/todo.txt"}, nil, nil}
+	for i, e := range entries {
+		if !reflect.DeepEqual(e.Paths, wantPaths[i]) {
+			t.Errorf("entry %d paths = %q, want %q", i, e.Paths, wantPaths[i])
+		}
+	}
+}
+
+func TestUnescapeFish(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`a\nb`, "a\nb"},
+		{`a\\nb`, `a\nb`},
+		{`C:\\path`, `C:\path`},
+		// Other escapes are left alone
+		{`say \"hi\"`, `say \"hi\"`},
+		{`trailing\`, `trailing\`},
+	}
+
+	for _, tt := range tests {
+		if got := unescapeFish(tt.in); got != tt.want {
+			t.Errorf("unescapeFish(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
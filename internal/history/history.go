@@ -2,244 +2,329 @@ package history
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/mitchellh/go-homedir"
+	"mvdan.cc/sh/v3/syntax"
 )
 
-// CommandEntry represents a command with its timestamp
+// CommandEntry represents a command with its timestamp and whatever
+// other metadata the history source records
 type CommandEntry struct {
 	Command   string
 	Timestamp time.Time
+	Paths     []string      // Files the command referenced, when the shell records them (fish)
+	ExitCode  *int          // Exit status, nil when the source doesn't record it
+	Duration  time.Duration // How long the command ran, zero when unknown
+	Cwd       string        // Working directory the command ran in
+	Hostname  string        // Machine the command ran on
+	Session   string        // Shell session identifier
+	Shell     string        // Name of the history source the entry was read from
 }
 
-// GetShellHistory returns the shell command history
-func GetShellHistory(limit int) ([]string, error) {
-	// Detect shell
-	shell := os.Getenv("SHELL")
-
-	home, err := homedir.Dir()
+// GetShellHistory returns the command history of the given source, or of the
+// source detected from $SHELL when shell is empty
+func GetShellHistory(shell string, limit int) ([]string, error) {
+	entries, err := GetCommandsWithTimestamps(shell, limit)
 	if err != nil {
 		return nil, err
 	}
 
-	var historyFile string
-	var parseHistoryFile func(string, int) ([]string, error)
-
-	// Determine history file and parse function based on shell
-	if strings.Contains(shell, "zsh") {
-		historyFile = filepath.Join(home, ".zsh_history")
-		parseHistoryFile = parseZshHistory
-	} else if strings.Contains(shell, "bash") {
-		historyFile = filepath.Join(home, ".bash_history")
-		parseHistoryFile = parseBashHistory
-	} else if strings.Contains(shell, "fish") {
-		historyFile = filepath.Join(home, ".local", "share", "fish", "fish_history")
-		parseHistoryFile = parseFishHistory
-	} else {
-		// Fallback to bash history format
-		historyFile = filepath.Join(home, ".bash_history")
-		parseHistoryFile = parseBashHistory
-	}
+	return Commands(entries), nil
+}
 
-	// Check if history file exists
-	if _, err := os.Stat(historyFile); os.IsNotExist(err) {
-		return []string{"No history file found"}, nil
+// Commands extracts the command strings from a slice of entries
+func Commands(entries []CommandEntry) []string {
+	commands := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		commands = append(commands, entry.Command)
 	}
+	return commands
+}
 
-	// Parse history file with the appropriate function
-	commands, err := parseHistoryFile(historyFile, limit)
+// parseEpoch converts a unix timestamp string into a time, returning the zero time if invalid
+func parseEpoch(s string) time.Time {
+	secs, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing history file: %v", err)
+		return time.Time{}
 	}
-
-	return commands, nil
+	return unixTime(secs)
 }
 
-// parseBashHistory efficiently parses bash history file
-func parseBashHistory(historyFile string, limit int) ([]string, error) {
-	file, err := os.Open(historyFile)
-	if err != nil {
-		return nil, err
+// unixTime converts seconds since the epoch into a time, treating non-positive values as unknown
+func unixTime(secs int64) time.Time {
+	if secs <= 0 {
+		return time.Time{}
 	}
-	defer file.Close()
-
-	// Create a circular buffer-like structure of fixed capacity
-	commands := make([]string, 0, limit)
+	return time.Unix(secs, 0)
+}
 
-	scanner := bufio.NewScanner(file)
-	lineCount := 0
+// maxLineSize is the longest physical line the history parsers accept
+const maxLineSize = 1024 * 1024
 
-	// For very large history files, we might need to set a custom buffer
-	// This allows scanning lines longer than the default buffer size
-	const maxCapacity = 1024 * 1024 // 1MB
-	buf := make([]byte, 64*1024)    // 64KB initial buffer
-	scanner.Buffer(buf, maxCapacity)
+// newScanner returns a line scanner for history files, which can hold lines
+// much longer than bufio's default limit
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return scanner
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineCount++
+// keepLast returns the most recent limit entries, or all of them when limit is not positive
+func keepLast(entries []CommandEntry, limit int) []CommandEntry {
+	if limit > 0 && len(entries) > limit {
+		return entries[len(entries)-limit:]
+	}
+	return entries
+}
 
-		// Skip timestamp lines if present (they start with #)
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
+// maxEntryLines caps how many physical lines are merged into a single command,
+// so an unterminated heredoc can't swallow the rest of the history file
+const maxEntryLines = 500
 
-		// Parse the command line
-		cmd := strings.TrimSpace(line)
-		if cmd != "" {
-			commands = append(commands, cmd)
+// zshMeta is the marker byte zsh uses to escape special bytes in its history file
+const zshMeta = 0x83
 
-			// If we exceed the limit, remove oldest commands
-			if limit > 0 && len(commands) > limit {
-				// Shift elements by removing the oldest
-				commands = commands[1:]
-			}
-		}
-	}
+// heredocRe matches a heredoc operator and captures its delimiter word,
+// ignoring "<<<" here-strings
+var heredocRe = regexp.MustCompile(`(?:^|[^<])<<(-?)\s*['"]?([A-Za-z_][A-Za-z0-9_]*)['"]?`)
 
-	if err := scanner.Err(); err != nil {
-		return commands, fmt.Errorf("error reading history file: %v", err)
+// heredocDelimiter returns the delimiter of a heredoc started on line, or "" if there is none
+func heredocDelimiter(line string) string {
+	matches := heredocRe.FindStringSubmatch(line)
+	if matches == nil {
+		return ""
 	}
+	return matches[2]
+}
 
-	return commands, nil
+// hasLineContinuation reports whether line ends with an unescaped backslash
+func hasLineContinuation(line string) bool {
+	trailing := len(line) - len(strings.TrimRight(line, "\\"))
+	return trailing%2 == 1
 }
 
-// parseZshHistory efficiently parses zsh history file
-func parseZshHistory(historyFile string, limit int) ([]string, error) {
-	file, err := os.Open(historyFile)
-	if err != nil {
-		return nil, err
+// unmetafy decodes zsh's metafied encoding, where bytes that clash with
+// shell internals (including most non-ASCII bytes) are written as Meta
+// followed by the original byte XOR 32
+func unmetafy(b []byte) []byte {
+	if bytes.IndexByte(b, zshMeta) < 0 {
+		return b
 	}
-	defer file.Close()
 
-	// Create a circular buffer-like structure of fixed capacity
-	commands := make([]string, 0, limit)
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == zshMeta && i+1 < len(b) {
+			i++
+			out = append(out, b[i]^32)
+		} else {
+			out = append(out, b[i])
+		}
+	}
+	return out
+}
 
-	// ZSH history format regexp: ": TIMESTAMP:0;COMMAND"
-	// or simply "COMMAND" without timestamp
-	re := regexp.MustCompile(`: (\d+):\d+;(.*)`)
+// parseBashHistory efficiently parses bash history file
+func parseBashHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	return readTail(ctx, historyFile, limit, parseBashEntries, bashEntryStart)
+}
 
-	scanner := bufio.NewScanner(file)
+// parseBashEntries parses bash entries from r, keeping the most recent limit
+func parseBashEntries(r io.Reader, limit int) ([]CommandEntry, error) {
+	var entries []CommandEntry
+
+	// Bash writes "#EPOCH" lines before each command when HISTTIMEFORMAT is set
+	timestampRe := regexp.MustCompile(`^#(\d+)$`)
+	var timestamp time.Time
+
+	// Physical lines belonging to the command currently being rebuilt. When an
+	// entry is preceded by a timestamp every line up to the next timestamp is
+	// collected and split afterwards, since with lithist a command keeps its
+	// newlines; otherwise we rely on backslash continuations and heredoc
+	// delimiters to find where a command ends.
+	var pending []string
+	timestamped := false
+	continued := false
+	heredoc := ""
+
+	add := func(cmd string, timestamp time.Time) {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			return
+		}
+		entries = append(entries, CommandEntry{Command: cmd, Timestamp: timestamp})
+	}
 
-	// For very large history files, we might need to set a custom buffer
-	const maxCapacity = 1024 * 1024 // 1MB
-	buf := make([]byte, 64*1024)    // 64KB initial buffer
-	scanner.Buffer(buf, maxCapacity)
+	flush := func() {
+		if timestamped && len(pending) > 1 {
+			// Only the first command under a timestamp was given it; any
+			// others are entries that were saved without one
+			for i, cmd := range splitTimestamped(pending) {
+				if i == 0 {
+					add(cmd, timestamp)
+				} else {
+					add(cmd, time.Time{})
+				}
+			}
+		} else {
+			add(strings.Join(pending, "\n"), timestamp)
+		}
+		pending = pending[:0]
+		timestamp = time.Time{}
+		timestamped = false
+		continued = false
+		heredoc = ""
+	}
+
+	scanner := newScanner(r)
 
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		// Check for the timestamp format
-		matches := re.FindStringSubmatch(line)
-		if len(matches) > 2 {
-			// Extract command from timestamp format
-			cmd := strings.TrimSpace(matches[2])
-			if cmd != "" {
-				commands = append(commands, cmd)
+		if heredoc == "" && !continued {
+			// A timestamp line always starts a new entry
+			if matches := timestampRe.FindStringSubmatch(line); matches != nil {
+				flush()
+				timestamp = parseEpoch(matches[1])
+				timestamped = true
+				continue
 			}
-		} else {
-			// Try direct approach if regex fails
-			parts := strings.SplitN(line, ";", 2)
-			if len(parts) > 1 {
-				cmd := strings.TrimSpace(parts[1])
-				if cmd != "" {
-					commands = append(commands, cmd)
-				}
-			} else if strings.TrimSpace(line) != "" {
-				// If it's not a timestamp format but not empty either
-				commands = append(commands, strings.TrimSpace(line))
+
+			// Without timestamps each complete line is its own command
+			if len(pending) > 0 && !timestamped {
+				flush()
+			}
+
+			// Skip comment and blank lines between commands
+			if len(pending) == 0 && (strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "") {
+				continue
+			}
+		}
+
+		pending = append(pending, line)
+
+		if heredoc != "" {
+			// "<<-" allows the closing delimiter to be indented with tabs
+			if strings.TrimLeft(line, "\t") == heredoc {
+				heredoc = ""
 			}
+		} else {
+			heredoc = heredocDelimiter(line)
 		}
+		continued = heredoc == "" && hasLineContinuation(line)
 
-		// If we exceed the limit, remove oldest commands
-		if limit > 0 && len(commands) > limit {
-			// Shift elements by removing the oldest
-			commands = commands[1:]
+		if len(pending) >= maxEntryLines {
+			flush()
 		}
 	}
+	flush()
 
+	entries = keepLast(entries, limit)
 	if err := scanner.Err(); err != nil {
-		return commands, fmt.Errorf("error reading history file: %v", err)
+		return entries, fmt.Errorf("error reading history file: %v", err)
 	}
 
-	return commands, nil
+	return entries, nil
 }
 
-// parseFishHistory parses fish shell history which is stored in a different format
-func parseFishHistory(filePath string, limit int) ([]string, error) {
-	// Fish history is stored in a more complex format
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return []string{"No fish history file found"}, nil
+// splitTimestamped splits the lines bash wrote under one timestamp into
+// commands. With lithist, a multi-line command keeps its newlines and all of
+// it follows the timestamp. But when only some entries have timestamps, the
+// untimestamped commands after one end up under it too, so a line only
+// continues the command before it if that command is still unfinished.
+func splitTimestamped(lines []string) []string {
+	parser := syntax.NewParser()
+	var commands []string
+	start := 0
+	for i := range lines {
+		if i+1 < len(lines) && unfinished(parser, lines[start:i+1]) {
+			continue
+		}
+		commands = append(commands, strings.Join(lines[start:i+1], "\n"))
+		start = i + 1
 	}
+	return commands
+}
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
+// unfinished reports whether lines stop partway through a command, like an
+// open quote, loop or heredoc, or a trailing backslash, pipe or &&
+func unfinished(parser *syntax.Parser, lines []string) bool {
+	if hasLineContinuation(lines[len(lines)-1]) {
+		return true
 	}
-	defer file.Close()
+	_, err := parser.Parse(strings.NewReader(strings.Join(lines, "\n")), "")
+	return syntax.IsIncomplete(err)
+}
 
-	// Create a circular buffer-like structure of fixed capacity
-	commands := make([]string, 0, limit)
+// parseZshHistory efficiently parses zsh history file
+func parseZshHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	return readTail(ctx, historyFile, limit, parseZshEntries, zshEntryStart)
+}
 
-	// Fish history entry looks like:
-	// - cmd: the actual command
-	// - when: timestamp
-	type fishEntry struct {
-		Cmd  string `json:"cmd"`
-		When int64  `json:"when"`
-	}
+// parseZshEntries parses zsh entries from r, keeping the most recent limit
+func parseZshEntries(r io.Reader, limit int) ([]CommandEntry, error) {
+	var entries []CommandEntry
+
+	// ZSH history format regexp: ": TIMESTAMP:0;COMMAND"
+	// or simply "COMMAND" without timestamp
+	re := regexp.MustCompile(`(?s)^: (\d+):\d+;(.*)`)
 
-	// Fish history can be quite large, so we need a robust scanner
-	scanner := bufio.NewScanner(file)
+	addEntry := func(line string) {
+		// Check for the timestamp format
+		matches := re.FindStringSubmatch(line)
+		if len(matches) > 2 {
+			// Extract command and timestamp from extended history format
+			cmd := strings.TrimSpace(matches[2])
+			if cmd != "" {
+				entries = append(entries, CommandEntry{Command: cmd, Timestamp: parseEpoch(matches[1])})
+			}
+		} else if cmd := strings.TrimSpace(line); cmd != "" {
+			// A plain history line is the whole command, semicolons and all
+			entries = append(entries, CommandEntry{Command: cmd})
+		}
+	}
 
-	// For very large history files, we might need to set a custom buffer
-	const maxCapacity = 1024 * 1024 // 1MB
-	buf := make([]byte, 64*1024)    // 64KB initial buffer
-	scanner.Buffer(buf, maxCapacity)
+	scanner := newScanner(r)
 
-	// Regular expression for extracting command from non-JSON format
-	cmdRegex := regexp.MustCompile(`- cmd: (.+)`)
+	// zsh stores each newline inside a command as a backslash at the end of
+	// the line, so keep joining lines until one doesn't end with a backslash
+	var current strings.Builder
+	lineCount := 0
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+		line := string(unmetafy(scanner.Bytes()))
 
-		// Try to parse as JSON
-		var entry fishEntry
-		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Cmd != "" {
-			commands = append(commands, entry.Cmd)
-		} else {
-			// Fall back to regex
-			matches := cmdRegex.FindStringSubmatch(line)
-			if len(matches) > 1 {
-				cmd := strings.TrimSpace(matches[1])
-				if cmd != "" {
-					commands = append(commands, cmd)
-				}
-			}
+		if lineCount > 0 {
+			current.WriteByte('\n')
 		}
+		lineCount++
 
-		// If we exceed the limit, remove oldest commands
-		if limit > 0 && len(commands) > limit {
-			// Shift elements by removing the oldest
-			commands = commands[1:]
+		if strings.HasSuffix(line, "\\") && lineCount < maxEntryLines {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			continue
 		}
+
+		current.WriteString(line)
+		addEntry(current.String())
+		current.Reset()
+		lineCount = 0
+	}
+	if lineCount > 0 {
+		addEntry(current.String())
 	}
 
+	entries = keepLast(entries, limit)
 	if err := scanner.Err(); err != nil {
-		return commands, fmt.Errorf("error reading fish history file: %v", err)
+		return entries, fmt.Errorf("error reading history file: %v", err)
 	}
 
-	return commands, nil
+	return entries, nil
 }
 
 // GetMostRecentCommands returns only the most recent commands
@@ -253,16 +338,21 @@ func GetMostRecentCommands(allCommands []string, limit int) []string {
 	return allCommands[len(allCommands)-limit:]
 }
 
-// GetCommandsWithTimestamps attempts to get commands with timestamps from history
-// This is a more advanced function that tries to extract timing information
-// Only works for shells that store timestamp information (zsh, fish)
-func GetCommandsWithTimestamps(limit int) ([]CommandEntry, error) {
-	// This is a placeholder implementation - would need specific shell handling
-	// Similar to GetShellHistory but with timestamp extraction
-	shell := os.Getenv("SHELL")
+// GetCommandsWithTimestamps returns commands along with the time they were run.
+// Timestamps are only available for sources that record them (zsh extended
+// history, fish, nushell, xonsh, tcsh, and bash with HISTTIMEFORMAT set);
+// entries without timing information have a zero Timestamp.
+//
+// shell may name a single source, a comma separated list of sources, or "all"
+// to merge every source with history on this machine. An empty shell detects
+// the source from $SHELL.
+func GetCommandsWithTimestamps(shell string, limit int) ([]CommandEntry, error) {
+	sources, err := SelectSources(shell, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	// For now just return empty
-	return []CommandEntry{}, fmt.Errorf("timestamp extraction not implemented for %s", shell)
+	return ReadSources(context.Background(), sources, limit)
 }
 
 // FilterCommands returns commands matching the given pattern
@@ -0,0 +1,158 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// parsed is the part of an entry the parser tests compare
+type parsed struct {
+	command   string
+	timestamp int64
+}
+
+// summarize reduces entries to their commands and unix timestamps, with 0 for none
+func summarize(entries []CommandEntry) []parsed {
+	out := make([]parsed, len(entries))
+	for i, e := range entries {
+		out[i].command = e.Command
+		if !e.Timestamp.IsZero() {
+			out[i].timestamp = e.Timestamp.Unix()
+		}
+	}
+	return out
+}
+
+func TestParseZshHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		limit int
+		want  []parsed
+	}{
+		{
+			name: "extended history with metafied bytes and continuations",
+			file: "zsh_extended",
+			want: []parsed{
+				{"ls -la", 1700000000},
+				{"echo 日本", 1700000010},
+				{"for f in *.go; do\n  gofmt -l $f\ndone", 1700000020},
+				{"git status", 1700000030},
+			},
+		},
+		{
+			name:  "limit keeps the most recent",
+			file:  "zsh_extended",
+			limit: 2,
+			want: []parsed{
+				{"for f in *.go; do\n  gofmt -l $f\ndone", 1700000020},
+				{"git status", 1700000030},
+			},
+		},
+		{
+			name: "plain history without timestamps keeps semicolons",
+			file: "zsh_plain",
+			want: []parsed{
+				{"ls", 0},
+				{"cd /tmp", 0},
+				{"git log --oneline", 0},
+				{"echo a; echo b", 0},
+				{"for i in 1 2; do echo $i; done", 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseZshHistory(context.Background(), filepath.Join("testdata", tt.file), tt.limit)
+			if err != nil {
+				t.Fatalf("parseZshHistory: %v", err)
+			}
+			if got := summarize(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBashHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		limit int
+		want  []parsed
+	}{
+		{
+			name: "continuations, heredocs and comments",
+			file: "bash_plain",
+			want: []parsed{
+				{"ls -la", 0},
+				{"echo one \\\n  two", 0},
+				{"cat <<EOF > out.txt\nhello\nEOF", 0},
+				{"git status", 0},
+			},
+		},
+		{
+			name: "lithist keeps multi-line commands under their timestamp",
+			file: "bash_lithist",
+			want: []parsed{
+				{"ls", 1700000000},
+				{"for i in 1 2; do\n  echo $i\ndone", 1700000010},
+				{"git status", 1700000020},
+			},
+		},
+		{
+			name: "timestamps on only some entries",
+			file: "bash_mixed",
+			want: []parsed{
+				{"ls", 0},
+				{"cd /tmp", 1700000000},
+				{"make", 0},
+				{"git status", 0},
+				{"echo \"multi\nline\"", 1700000010},
+				{"npm test", 0},
+			},
+		},
+		{
+			name:  "limit keeps the most recent",
+			file:  "bash_mixed",
+			limit: 3,
+			want: []parsed{
+				{"git status", 0},
+				{"echo \"multi\nline\"", 1700000010},
+				{"npm test", 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseBashHistory(context.Background(), filepath.Join("testdata", tt.file), tt.limit)
+			if err != nil {
+				t.Fatalf("parseBashHistory: %v", err)
+			}
+			if got := summarize(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmetafy(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain ascii", "plain ascii"},
+		{"\xe6\x83\xb7\xa5", "日"},
+		// A trailing Meta byte has nothing to decode, so it's kept
+		{"abc\x83", "abc\x83"},
+	}
+
+	for _, tt := range tests {
+		if got := string(unmetafy([]byte(tt.in))); got != tt.want {
+			t.Errorf("unmetafy(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// AllSources is the --shell value that merges every available history source
+const AllSources = "all"
+
+// pathSource is implemented by sources backed by a single file, so merged
+// reads can avoid reading the same file twice
+type pathSource interface {
+	Path() (string, error)
+}
+
+// SelectSources resolves a --shell value and a list of --history-file specs
+// into the sources to read. shell may be empty (detect from $SHELL unless
+// files are given), a single source name, a comma separated list, or "all".
+func SelectSources(shell string, files []string) ([]HistorySource, error) {
+	var sources []HistorySource
+
+	for _, spec := range files {
+		src, err := FileSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+
+	shell = strings.TrimSpace(shell)
+	switch {
+	case shell == "" && len(sources) > 0:
+		// Explicit files replace $SHELL detection
+	case shell == "":
+		src, err := DetectSource()
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	case strings.EqualFold(shell, AllSources):
+		for _, src := range registry {
+			if src.Available() {
+				sources = append(sources, src)
+			}
+		}
+		if len(sources) == 0 {
+			return nil, ErrNoHistory
+		}
+	default:
+		for _, name := range strings.Split(shell, ",") {
+			src, err := LookupSource(name)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, src)
+		}
+	}
+
+	return sources, nil
+}
+
+// FileSource builds a history source for an explicit file. The spec is either
+// "source:path" (e.g. "zsh:/mnt/old/.zsh_history") or a bare path whose file
+// name matches one of the registered sources' default history files.
+func FileSource(spec string) (HistorySource, error) {
+	if name, path, ok := strings.Cut(spec, ":"); ok {
+		if src, err := LookupSource(name); err == nil {
+			if fs, ok := src.(fileSource); ok {
+				return fs.withFile(path)
+			}
+		}
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(spec)
+	for _, src := range registry {
+		fs, ok := src.(fileSource)
+		if !ok {
+			continue
+		}
+		for _, file := range fs.files(home) {
+			if filepath.Base(file.path) == base {
+				file.path = spec
+				return fileSource{
+					name:  fs.name,
+					files: func(string) []historyFile { return []historyFile{file} },
+				}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("can't tell which shell wrote %s; prefix it with the source name, e.g. zsh:%s", spec, spec)
+}
+
+// withFile returns a copy of the source that reads only the given file,
+// using the parser of the candidate with the same file name when there is one
+func (s fileSource) withFile(path string) (HistorySource, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := s.files(home)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%s history can't be read from a file", s.name)
+	}
+
+	chosen := candidates[0]
+	for _, file := range candidates {
+		if filepath.Base(file.path) == filepath.Base(path) {
+			chosen = file
+		}
+	}
+	chosen.path = path
+
+	return fileSource{
+		name:  s.name,
+		files: func(string) []historyFile { return []historyFile{chosen} },
+	}, nil
+}
+
+// ReadSources reads every source and merges the results into a single
+// time-ordered history of at most limit entries. Sources that share a file are
+// only read once, and entries recorded by more than one source are dropped.
+// Reading stops part way through a source once ctx is cancelled.
+func ReadSources(ctx context.Context, sources []HistorySource, limit int) ([]CommandEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sources) == 1 {
+		entries, err := sources[0].Read(ctx, limit)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s history: %w", sources[0].Name(), err)
+		}
+		return entries, nil
+	}
+
+	var streams [][]CommandEntry
+	var errs []error
+	seenFiles := make(map[string]bool)
+
+	for _, src := range sources {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if ps, ok := src.(pathSource); ok {
+			if path, err := ps.Path(); err == nil {
+				if resolved, err := filepath.EvalSymlinks(path); err == nil {
+					path = resolved
+				}
+				if seenFiles[path] {
+					continue
+				}
+				seenFiles[path] = true
+			}
+		}
+
+		entries, err := src.Read(ctx, limit)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading %s history: %w", src.Name(), err))
+			continue
+		}
+		streams = append(streams, entries)
+	}
+
+	// A merged read only fails if nothing could be read at all
+	if len(streams) == 0 {
+		if len(errs) == 0 {
+			return nil, ErrNoHistory
+		}
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	}
+
+	return keepLast(mergeStreams(streams), limit), nil
+}
+
+// mergeStreams interleaves several oldest-first histories by timestamp.
+// Entries without a timestamp keep their position relative to their
+// neighbours by borrowing the timestamp of the entry before them.
+func mergeStreams(streams [][]CommandEntry) []CommandEntry {
+	type keyed struct {
+		entry CommandEntry
+		key   time.Time
+	}
+
+	var all []keyed
+	for _, stream := range streams {
+		var last time.Time
+		for _, entry := range stream {
+			if !entry.Timestamp.IsZero() {
+				last = entry.Timestamp
+			}
+			all = append(all, keyed{entry, last})
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].key.Before(all[j].key)
+	})
+
+	// The same command at the same second from two sources (e.g. zsh and
+	// atuin both recording it) is a single command
+	type dedupeKey struct {
+		command string
+		unix    int64
+	}
+	seen := make(map[dedupeKey]bool)
+
+	merged := make([]CommandEntry, 0, len(all))
+	for _, k := range all {
+		if !k.entry.Timestamp.IsZero() {
+			key := dedupeKey{k.entry.Command, k.entry.Timestamp.Unix()}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		merged = append(merged, k.entry)
+	}
+
+	return merged
+}
@@ -0,0 +1,214 @@
+package history
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// fakeHome points every history location at an empty temporary home directory
+func fakeHome(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("XONSH_DATA_DIR", "")
+
+	homedir.DisableCache = true
+	t.Cleanup(func() { homedir.DisableCache = false })
+	return home
+}
+
+// writeTemp writes content to name in dir and returns its path
+func writeTemp(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sourceNames returns the name of each source in order
+func sourceNames(sources []HistorySource) []string {
+	names := make([]string, len(sources))
+	for i, src := range sources {
+		names[i] = src.Name()
+	}
+	return names
+}
+
+func TestSelectSources(t *testing.T) {
+	home := fakeHome(t)
+	writeTemp(t, home, ".bash_history", "ls\n")
+	writeTemp(t, home, ".zsh_history", ": 1700000000:0;ls\n")
+	t.Setenv("SHELL", "/usr/bin/fish")
+
+	tests := []struct {
+		name    string
+		shell   string
+		files   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "detected from $SHELL", want: []string{"fish"}},
+		{name: "single name", shell: "bash", want: []string{"bash"}},
+		{name: "comma separated list in order", shell: "bash, zsh", want: []string{"bash", "zsh"}},
+		{name: "shell executable names work too", shell: "nushell", want: []string{"nu"}},
+		{name: "all is every source with history", shell: "ALL", want: []string{"zsh", "bash"}},
+		{name: "files replace detection", files: []string{"zsh:/old/history"}, want: []string{"zsh"}},
+		{name: "files come before named sources", shell: "bash", files: []string{"fish:/old/fish_history"}, want: []string{"fish", "bash"}},
+		{name: "unknown source", shell: "cmd.exe", wantErr: true},
+		{name: "unknown file", files: []string{"/old/history.log"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := SelectSources(tt.shell, tt.files)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SelectSources = %q, want an error", sourceNames(sources))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectSources: %v", err)
+			}
+			if got := sourceNames(sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sources = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	fakeHome(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		spec     string
+		wantName string
+		wantPath string
+	}{
+		{name: "source:path", spec: "zsh:" + filepath.Join(dir, ".zsh_history"), wantName: "zsh", wantPath: filepath.Join(dir, ".zsh_history")},
+		{name: "source:path with any file name", spec: "bash:" + filepath.Join(dir, "history.bak"), wantName: "bash", wantPath: filepath.Join(dir, "history.bak")},
+		{name: "bare path by file name", spec: filepath.Join(dir, ".bash_history"), wantName: "bash", wantPath: filepath.Join(dir, ".bash_history")},
+		{name: "bare path of a data dir file", spec: filepath.Join(dir, "fish_history"), wantName: "fish", wantPath: filepath.Join(dir, "fish_history")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTemp(t, dir, filepath.Base(tt.wantPath), "")
+
+			src, err := FileSource(tt.spec)
+			if err != nil {
+				t.Fatalf("FileSource: %v", err)
+			}
+			if src.Name() != tt.wantName {
+				t.Errorf("name = %q, want %q", src.Name(), tt.wantName)
+			}
+			if path, err := src.(pathSource).Path(); err != nil || path != tt.wantPath {
+				t.Errorf("path = %q, %v, want %q", path, err, tt.wantPath)
+			}
+		})
+	}
+
+	// A source with several formats reads the file with the parser matching its name
+	path := writeTemp(t, dir, "nushell/history.txt", "ls -la\necho a<\\n>b\n")
+	src, err := FileSource("nu:" + path)
+	if err != nil {
+		t.Fatalf("FileSource: %v", err)
+	}
+	entries, err := src.Read(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got, want := Commands(entries), []string{"ls -la", "echo a\nb"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+
+	if _, err := FileSource("/mnt/old/history.log"); err == nil {
+		t.Error("expected an error for a file no source claims")
+	}
+}
+
+func TestMergeStreams(t *testing.T) {
+	at := func(secs int64, nanos int64) time.Time { return time.Unix(secs, nanos) }
+
+	zsh := []CommandEntry{
+		{Command: "ls", Timestamp: at(100, 0), Shell: "zsh"},
+		{Command: "make", Timestamp: at(300, 0), Shell: "zsh"},
+		{Command: "git status", Timestamp: at(500, 0), Shell: "zsh"},
+	}
+	atuin := []CommandEntry{
+		{Command: "cd src", Timestamp: at(200, 0), Shell: "atuin"},
+		// zsh recorded this one too, at the same second
+		{Command: "make", Timestamp: at(300, 250_000_000), Shell: "atuin"},
+		// Untimed entries stay after the entry before them
+		{Command: "vim main.go", Shell: "atuin"},
+		{Command: "make", Timestamp: at(400, 0), Shell: "atuin"},
+	}
+	bash := []CommandEntry{
+		{Command: "echo hi"},
+		{Command: "echo hi"},
+	}
+
+	got := mergeStreams([][]CommandEntry{zsh, atuin, bash})
+
+	// Untimed entries at the start of a stream sort first, and are never deduped
+	want := []string{"echo hi", "echo hi", "ls", "cd src", "make", "vim main.go", "make", "git status"}
+	if commands := Commands(got); !reflect.DeepEqual(commands, want) {
+		t.Fatalf("merged = %q, want %q", commands, want)
+	}
+
+	// The first source to record a duplicate keeps it
+	if got[4].Shell != "zsh" {
+		t.Errorf("kept the %s copy of the duplicate, want zsh", got[4].Shell)
+	}
+}
+
+func TestReadSourcesSharedFile(t *testing.T) {
+	fakeHome(t)
+	dir := t.TempDir()
+	zshFile := writeTemp(t, dir, ".zsh_history", ": 100:0;ls\n: 300:0;make\n")
+	bashFile := writeTemp(t, dir, ".bash_history", "#200\ncd src\n#300\nmake\n#400\ngit push\n")
+
+	var sources []HistorySource
+	for _, spec := range []string{"zsh:" + zshFile, zshFile, "bash:" + bashFile} {
+		src, err := FileSource(spec)
+		if err != nil {
+			t.Fatalf("FileSource(%q): %v", spec, err)
+		}
+		sources = append(sources, src)
+	}
+
+	entries, err := ReadSources(context.Background(), sources, 0)
+	if err != nil {
+		t.Fatalf("ReadSources: %v", err)
+	}
+
+	want := []parsed{{"ls", 100}, {"cd src", 200}, {"make", 300}, {"git push", 400}}
+	if got := summarize(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	limited, err := ReadSources(context.Background(), sources, 2)
+	if err != nil {
+		t.Fatalf("ReadSources: %v", err)
+	}
+	if got, want := summarize(limited), []parsed{{"make", 300}, {"git push", 400}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("limited = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// nushellNewline is how reedline's plaintext history escapes newlines inside a command
+const nushellNewline = "<\\n>"
+
+// parseNushellText parses nushell's plaintext history.txt, one command per line
+func parseNushellText(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	return readTail(ctx, historyFile, limit, parseNushellTextEntries, anyEntryStart)
+}
+
+// parseNushellTextEntries parses nushell plaintext entries from r, keeping the most recent limit
+func parseNushellTextEntries(r io.Reader, limit int) ([]CommandEntry, error) {
+	var entries []CommandEntry
+
+	scanner := newScanner(r)
+
+	for scanner.Scan() {
+		cmd := strings.TrimSpace(strings.ReplaceAll(scanner.Text(), nushellNewline, "\n"))
+		if cmd == "" {
+			continue
+		}
+
+		entries = append(entries, CommandEntry{Command: cmd})
+	}
+
+	entries = keepLast(entries, limit)
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("error reading nushell history file: %v", err)
+	}
+
+	return entries, nil
+}
+
+// parseNushellSQLite reads nushell's history.sqlite3 database
+func parseNushellSQLite(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	db, err := openSQLite(historyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Fetch the newest rows first so the limit keeps the most recent commands
+	rows, err := db.QueryContext(ctx, `SELECT command_line, COALESCE(start_timestamp, 0)
+		FROM history ORDER BY id DESC LIMIT ?`, sqlLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("error querying nushell history: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []CommandEntry
+	for rows.Next() {
+		var cmd string
+		var startMillis int64
+		if err := rows.Scan(&cmd, &startMillis); err != nil {
+			return nil, fmt.Errorf("error reading nushell history: %v", err)
+		}
+
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			continue
+		}
+
+		entry := CommandEntry{Command: cmd}
+		if startMillis > 0 {
+			entry.Timestamp = time.UnixMilli(startMillis)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading nushell history: %v", err)
+	}
+
+	reverseEntries(entries)
+	return entries, nil
+}
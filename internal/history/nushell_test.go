@@ -0,0 +1,88 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeSQLite creates a database at dir/name by running each statement in order
+func writeSQLite(t *testing.T, dir, name string, statements ...string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return path
+}
+
+func TestParseNushellText(t *testing.T) {
+	entries, err := parseNushellText(context.Background(), filepath.Join("testdata", "nushell_history"), 0)
+	if err != nil {
+		t.Fatalf("parseNushellText: %v", err)
+	}
+
+	want := []parsed{
+		{"ls -la", 0},
+		{"echo first\nsecond", 0},
+		{"git status", 0},
+	}
+	if got := summarize(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseNushellSQLite(t *testing.T) {
+	path := writeSQLite(t, t.TempDir(), "history.sqlite3",
+		`CREATE TABLE history (id INTEGER PRIMARY KEY AUTOINCREMENT, command_line TEXT NOT NULL, start_timestamp INTEGER)`,
+		// Start timestamps are in milliseconds
+		`INSERT INTO history (command_line, start_timestamp) VALUES
+			('ls | where size > 1kb', 1700000000000),
+			('', 1700000005000),
+			('git status', NULL),
+			('cargo build', 1700000010000)`,
+	)
+
+	tests := []struct {
+		name  string
+		limit int
+		want  []parsed
+	}{
+		{
+			name: "all",
+			want: []parsed{
+				{"ls | where size > 1kb", 1700000000},
+				{"git status", 0},
+				{"cargo build", 1700000010},
+			},
+		},
+		{
+			name:  "limit keeps the most recent",
+			limit: 1,
+			want:  []parsed{{"cargo build", 1700000010}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseNushellSQLite(context.Background(), path, tt.limit)
+			if err != nil {
+				t.Fatalf("parseNushellSQLite: %v", err)
+			}
+			if got := summarize(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
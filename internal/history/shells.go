@@ -0,0 +1,135 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// parsePowerShellHistory parses PSReadLine's ConsoleHost_history.txt.
+// Multi-line commands are stored with a trailing backtick on every line but the last.
+func parsePowerShellHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	return readTail(ctx, historyFile, limit, parsePowerShellEntries, powerShellEntryStart)
+}
+
+// parsePowerShellEntries parses PowerShell entries from r, keeping the most recent limit
+func parsePowerShellEntries(r io.Reader, limit int) ([]CommandEntry, error) {
+	var entries []CommandEntry
+
+	scanner := newScanner(r)
+
+	var pending []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if strings.HasSuffix(line, "`") && len(pending) < maxEntryLines {
+			pending = append(pending, strings.TrimSuffix(line, "`"))
+			continue
+		}
+
+		pending = append(pending, line)
+		cmd := strings.TrimSpace(strings.Join(pending, "\n"))
+		pending = pending[:0]
+		if cmd == "" {
+			continue
+		}
+
+		entries = append(entries, CommandEntry{Command: cmd})
+	}
+
+	entries = keepLast(entries, limit)
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("error reading PowerShell history file: %v", err)
+	}
+
+	return entries, nil
+}
+
+// parseTcshHistory parses tcsh's ~/.history, where "#+EPOCH" lines precede each command
+func parseTcshHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	return readTail(ctx, historyFile, limit, parseTcshEntries, tcshEntryStart)
+}
+
+// parseTcshEntries parses tcsh entries from r, keeping the most recent limit
+func parseTcshEntries(r io.Reader, limit int) ([]CommandEntry, error) {
+	var entries []CommandEntry
+
+	timestampRe := regexp.MustCompile(`^#\+(\d+)$`)
+	var timestamp time.Time
+
+	scanner := newScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if matches := timestampRe.FindStringSubmatch(line); matches != nil {
+			timestamp = parseEpoch(matches[1])
+			continue
+		}
+
+		cmd := strings.TrimSpace(line)
+		if cmd == "" {
+			continue
+		}
+
+		entries = append(entries, CommandEntry{Command: cmd, Timestamp: timestamp})
+		timestamp = time.Time{}
+	}
+
+	entries = keepLast(entries, limit)
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("error reading tcsh history file: %v", err)
+	}
+
+	return entries, nil
+}
+
+// kshMagic is the header ksh93 writes at the start of its binary history file
+var kshMagic = []byte{0x81, 0x01}
+
+// parseKshHistory parses ksh history. ksh93 uses a binary file with a magic
+// header and NUL-terminated commands; pdksh and mksh in text mode use one
+// command per line.
+func parseKshHistory(ctx context.Context, historyFile string, limit int) ([]CommandEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(historyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var records [][]byte
+	if bytes.HasPrefix(data, kshMagic) {
+		records = bytes.Split(data[len(kshMagic):], []byte{0})
+	} else {
+		records = bytes.Split(data, []byte{'\n'})
+	}
+
+	entries := make([]CommandEntry, 0, len(records))
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// ksh93 also stores bookkeeping records that start with control bytes
+		if len(record) > 0 && record[0] >= 0x80 {
+			continue
+		}
+
+		cmd := strings.TrimSpace(string(record))
+		if cmd != "" {
+			entries = append(entries, CommandEntry{Command: cmd})
+		}
+	}
+
+	entries = keepLast(entries, limit)
+
+	return entries, nil
+}
@@ -0,0 +1,123 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePowerShellHistory(t *testing.T) {
+	entries, err := parsePowerShellHistory(context.Background(), filepath.Join("testdata", "pwsh_history"), 0)
+	if err != nil {
+		t.Fatalf("parsePowerShellHistory: %v", err)
+	}
+
+	want := []parsed{
+		{"git status", 0},
+		{"Get-ChildItem \n  -Recurse", 0},
+		{"ls", 0},
+	}
+	if got := summarize(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseTcshHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  []parsed
+	}{
+		{
+			name: "timestamps only apply to the next command",
+			want: []parsed{
+				{"ls -la", 1700000000},
+				{"make test", 1700000010},
+				{"git status", 0},
+			},
+		},
+		{
+			name:  "limit keeps the most recent",
+			limit: 1,
+			want:  []parsed{{"git status", 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseTcshHistory(context.Background(), filepath.Join("testdata", "tcsh_history"), tt.limit)
+			if err != nil {
+				t.Fatalf("parseTcshHistory: %v", err)
+			}
+			if got := summarize(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKshHistory(t *testing.T) {
+	// ksh93's binary format: magic header, NUL-terminated commands and
+	// bookkeeping records starting with a high byte
+	binary := filepath.Join(t.TempDir(), "ksh93_history")
+	data := append([]byte{0x81, 0x01}, "ls -la\x00\x81\x02\x00echo a\nb\x00git status\x00"...)
+	if err := os.WriteFile(binary, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		file  string
+		limit int
+		want  []parsed
+	}{
+		{
+			name: "text history",
+			file: filepath.Join("testdata", "ksh_text"),
+			want: []parsed{
+				{"ls", 0},
+				{"cd /tmp", 0},
+				{`print -r -- "a; b"`, 0},
+			},
+		},
+		{
+			name: "ksh93 binary history",
+			file: binary,
+			want: []parsed{
+				{"ls -la", 0},
+				{"echo a\nb", 0},
+				{"git status", 0},
+			},
+		},
+		{
+			name:  "limit keeps the most recent",
+			file:  binary,
+			limit: 1,
+			want:  []parsed{{"git status", 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseKshHistory(context.Background(), tt.file, tt.limit)
+			if err != nil {
+				t.Fatalf("parseKshHistory: %v", err)
+			}
+			if got := summarize(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKshHistoryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := parseKshHistory(ctx, filepath.Join("testdata", "ksh_text"), 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
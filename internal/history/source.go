@@ -0,0 +1,307 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// ErrNoHistory is returned when a source has no history file on this machine
+var ErrNoHistory = errors.New("no history file found")
+
+// HistorySource reads command history from a single shell or tool
+type HistorySource interface {
+	// Name is the identifier used to select the source with --shell
+	Name() string
+	// Matches reports whether the given shell executable (e.g. "zsh") belongs to this source
+	Matches(shell string) bool
+	// Available reports whether the source has history on this machine
+	Available() bool
+	// Read returns up to limit of the most recent entries, oldest first
+	Read(ctx context.Context, limit int) ([]CommandEntry, error)
+}
+
+// registry holds every known history source in detection priority order
+var registry []HistorySource
+
+// RegisterSource adds a history source to the registry.
+// Registering a second source with the same name replaces the first.
+func RegisterSource(src HistorySource) {
+	for i, existing := range registry {
+		if existing.Name() == src.Name() {
+			registry[i] = src
+			return
+		}
+	}
+	registry = append(registry, src)
+}
+
+// Sources returns all registered history sources
+func Sources() []HistorySource {
+	return append([]HistorySource(nil), registry...)
+}
+
+// SourceNames returns the names of all registered history sources
+func SourceNames() []string {
+	names := make([]string, 0, len(registry))
+	for _, src := range registry {
+		names = append(names, src.Name())
+	}
+	return names
+}
+
+// LookupSource finds a registered source by name or shell executable
+func LookupSource(name string) (HistorySource, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, src := range registry {
+		if src.Name() == name {
+			return src, nil
+		}
+	}
+	for _, src := range registry {
+		if src.Matches(name) {
+			return src, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown history source %q (available: %s)", name, strings.Join(SourceNames(), ", "))
+}
+
+// DetectSource picks the history source for the current shell from $SHELL.
+// If $SHELL is unset or unknown, the first source with history on this machine is used.
+func DetectSource() (HistorySource, error) {
+	shell := shellName(os.Getenv("SHELL"))
+	if shell != "" {
+		for _, src := range registry {
+			if src.Matches(shell) {
+				return src, nil
+			}
+		}
+	}
+
+	for _, src := range registry {
+		if src.Available() {
+			return src, nil
+		}
+	}
+
+	if shell == "" {
+		return nil, fmt.Errorf("could not detect your shell; pick one with --shell (available: %s)", strings.Join(SourceNames(), ", "))
+	}
+	return nil, fmt.Errorf("unsupported shell %q; pick a history source with --shell (available: %s)", shell, strings.Join(SourceNames(), ", "))
+}
+
+// shellName extracts the executable name from a shell path such as /usr/bin/zsh
+func shellName(path string) string {
+	name := strings.ToLower(filepath.Base(strings.TrimSpace(path)))
+	name = strings.TrimSuffix(name, ".exe")
+	if name == "." || name == string(filepath.Separator) {
+		return ""
+	}
+	return name
+}
+
+// historyFile is a candidate history location together with the parser for its format
+type historyFile struct {
+	path  string
+	parse func(context.Context, string, int) ([]CommandEntry, error)
+	// parseAppended parses a region of the file on its own. It is set for
+	// formats that only ever grow at the end, so lines appended since the
+	// last read can be parsed without reparsing the file.
+	parseAppended func(io.Reader, int) ([]CommandEntry, error)
+}
+
+// fileSource is a HistorySource backed by one or more files on disk.
+// The first candidate file that exists is the one that gets read.
+type fileSource struct {
+	name   string
+	shells []string
+	files  func(home string) []historyFile
+}
+
+func (s fileSource) Name() string {
+	return s.name
+}
+
+func (s fileSource) Matches(shell string) bool {
+	for _, candidate := range s.shells {
+		if candidate == shell {
+			return true
+		}
+	}
+	return false
+}
+
+func (s fileSource) Available() bool {
+	_, err := s.locate()
+	return err == nil
+}
+
+func (s fileSource) Read(ctx context.Context, limit int) ([]CommandEntry, error) {
+	file, err := s.locate()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CommandEntry
+	if activeCache != nil {
+		entries, err = activeCache.read(ctx, file, limit)
+	} else {
+		entries, err = file.parse(ctx, file.path, limit)
+	}
+	for i := range entries {
+		entries[i].Shell = s.name
+	}
+	return entries, err
+}
+
+// Path returns the history file the source would read, so merged reads can
+// skip sources that share a file
+func (s fileSource) Path() (string, error) {
+	file, err := s.locate()
+	if err != nil {
+		return "", err
+	}
+	return file.path, nil
+}
+
+// locate returns the first candidate history file that exists
+func (s fileSource) locate() (historyFile, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return historyFile{}, err
+	}
+
+	for _, file := range s.files(home) {
+		if _, err := os.Stat(file.path); err == nil {
+			return file, nil
+		}
+	}
+	return historyFile{}, ErrNoHistory
+}
+
+// dataDir returns the XDG data directory, where most modern shells keep their history
+func dataDir(home string) string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir
+	}
+	return filepath.Join(home, ".local", "share")
+}
+
+// configDir returns the platform configuration directory
+func configDir(home string) string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return dir
+	}
+	return filepath.Join(home, ".config")
+}
+
+func init() {
+	RegisterSource(fileSource{
+		name:   "zsh",
+		shells: []string{"zsh"},
+		files: func(home string) []historyFile {
+			return []historyFile{
+				{path: filepath.Join(home, ".zsh_history"), parse: parseZshHistory, parseAppended: parseZshEntries},
+				{path: filepath.Join(home, ".histfile"), parse: parseZshHistory, parseAppended: parseZshEntries},
+			}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name:   "bash",
+		shells: []string{"bash"},
+		files: func(home string) []historyFile {
+			return []historyFile{{path: filepath.Join(home, ".bash_history"), parse: parseBashHistory, parseAppended: parseBashEntries}}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name:   "fish",
+		shells: []string{"fish"},
+		files: func(home string) []historyFile {
+			return []historyFile{{path: filepath.Join(dataDir(home), "fish", "fish_history"), parse: parseFishHistory, parseAppended: parseFishEntries}}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name:   "nu",
+		shells: []string{"nu", "nushell"},
+		files: func(home string) []historyFile {
+			dir := filepath.Join(configDir(home), "nushell")
+			return []historyFile{
+				{path: filepath.Join(dir, "history.sqlite3"), parse: parseNushellSQLite},
+				{path: filepath.Join(dir, "history.txt"), parse: parseNushellText, parseAppended: parseNushellTextEntries},
+			}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name:   "xonsh",
+		shells: []string{"xonsh"},
+		files: func(home string) []historyFile {
+			dir := os.Getenv("XONSH_DATA_DIR")
+			if dir == "" {
+				dir = filepath.Join(dataDir(home), "xonsh")
+			}
+			return []historyFile{{path: filepath.Join(dir, "history_json"), parse: parseXonshHistory}}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name:   "pwsh",
+		shells: []string{"pwsh", "powershell"},
+		files: func(home string) []historyFile {
+			path := filepath.Join(dataDir(home), "powershell", "PSReadLine", "ConsoleHost_history.txt")
+			if runtime.GOOS == "windows" {
+				path = filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "PowerShell", "PSReadLine", "ConsoleHost_history.txt")
+			}
+			return []historyFile{{path: path, parse: parsePowerShellHistory, parseAppended: parsePowerShellEntries}}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name:   "tcsh",
+		shells: []string{"tcsh", "csh"},
+		files: func(home string) []historyFile {
+			return []historyFile{{path: filepath.Join(home, ".history"), parse: parseTcshHistory, parseAppended: parseTcshEntries}}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name:   "ksh",
+		shells: []string{"ksh", "ksh93", "mksh", "pdksh"},
+		files: func(home string) []historyFile {
+			return []historyFile{
+				{path: filepath.Join(home, ".sh_history"), parse: parseKshHistory},
+				{path: filepath.Join(home, ".mksh_history"), parse: parseKshHistory},
+			}
+		},
+	})
+
+	// History databases that sit alongside the shell rather than replacing it.
+	// They aren't tied to a shell, so $SHELL detection never picks them; select them with --shell.
+	RegisterSource(fileSource{
+		name: "atuin",
+		files: func(home string) []historyFile {
+			return []historyFile{{path: filepath.Join(dataDir(home), "atuin", "history.db"), parse: parseAtuinHistory}}
+		},
+	})
+
+	RegisterSource(fileSource{
+		name: "mcfly",
+		files: func(home string) []historyFile {
+			return []historyFile{
+				{path: filepath.Join(dataDir(home), "mcfly", "history.db"), parse: parseMcflyHistory},
+				{path: filepath.Join(home, ".mcfly", "history.db"), parse: parseMcflyHistory},
+				{path: filepath.Join(configDir(home), "McFly", "history.db"), parse: parseMcflyHistory},
+			}
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package history
+
+import (
+	"database/sql"
+	"net/url"
+
+	_ "modernc.org/sqlite" // pure Go driver, so history databases work without cgo
+)
+
+// openSQLite opens a history database read-only so we never interfere with
+// the shell that is writing to it
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := (&url.URL{Scheme: "file", Opaque: path, RawQuery: "mode=ro"}).String()
+	return sql.Open("sqlite", dsn)
+}
+
+// sqlLimit converts our limit convention (<= 0 means everything) to SQLite's (-1 means everything)
+func sqlLimit(limit int) int {
+	if limit <= 0 {
+		return -1
+	}
+	return limit
+}
+
+// reverseEntries reverses entries in place, turning newest-first query results into oldest-first history
+func reverseEntries(entries []CommandEntry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
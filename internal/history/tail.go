@@ -0,0 +1,176 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// tailChunkSize is the smallest block read from the end of a history file
+const tailChunkSize = 64 * 1024
+
+// tailBytesPerEntry is a rough guess of the average entry size, used to pick
+// how much of the file to read on the first pass
+const tailBytesPerEntry = 128
+
+var (
+	bashTimestampRe = regexp.MustCompile(`(?m)^#\d+$`)
+	tcshTimestampRe = regexp.MustCompile(`(?m)^#\+\d+$`)
+)
+
+// readTail parses only as much of the end of a history file as it takes to
+// find limit entries. It reads backwards from the end of the file in growing
+// chunks, finds the first complete entry in what it has read, and hands that
+// region to the forward parser. History files only grow at the end, so on
+// large files this avoids scanning megabytes of old history for every roast.
+// Parsing stops part way through once ctx is cancelled.
+func readTail(ctx context.Context, path string, limit int, parse func(io.Reader, int) ([]CommandEntry, error), findStart func([]byte) int) ([]CommandEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	parse = cancellable(ctx, parse)
+	if limit <= 0 {
+		return parse(file, limit)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	want := int64(limit) * tailBytesPerEntry
+	if want < tailChunkSize {
+		want = tailChunkSize
+	}
+
+	var tail []byte
+	offset := size
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// The whole file fits in what we'd read anyway, so just stream it
+		if want >= size {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return parse(file, limit)
+		}
+
+		// Prepend the next chunk before what we've already read
+		newOffset := size - want
+		chunk := make([]byte, offset-newOffset, want)
+		if _, err := file.ReadAt(chunk, newOffset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		offset = newOffset
+
+		if start := findStart(tail); start >= 0 {
+			entries, err := parse(bytes.NewReader(tail[start:]), limit)
+			if err != nil || len(entries) >= limit {
+				return entries, err
+			}
+		}
+
+		want *= 4
+	}
+}
+
+// cancellable wraps a forward parser so that it reads through ctxReader and
+// reports cancellation as ctx's own error
+func cancellable(ctx context.Context, parse func(io.Reader, int) ([]CommandEntry, error)) func(io.Reader, int) ([]CommandEntry, error) {
+	return func(r io.Reader, limit int) ([]CommandEntry, error) {
+		entries, err := parse(ctxReader{ctx: ctx, r: r}, limit)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return entries, err
+	}
+}
+
+// ctxReader fails reads once ctx is cancelled, which stops the scanner in
+// a parser at its next buffer fill
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// firstLineWhere returns the offset of the first line in window, after the
+// possibly partial first line, for which isStart(previousLine, line) is true,
+// or -1 if there is none
+func firstLineWhere(window []byte, isStart func(prev, line string) bool) int {
+	newline := bytes.IndexByte(window, '\n')
+	if newline < 0 {
+		return -1
+	}
+
+	prev := string(window[:newline])
+	pos := newline + 1
+	for pos < len(window) {
+		end := bytes.IndexByte(window[pos:], '\n')
+		if end < 0 {
+			end = len(window) - pos
+		}
+		line := strings.TrimSuffix(string(window[pos:pos+end]), "\r")
+		if isStart(prev, line) {
+			return pos
+		}
+		prev = line
+		pos += end + 1
+	}
+	return -1
+}
+
+// anyEntryStart treats every line as a separate entry
+func anyEntryStart(window []byte) int {
+	return firstLineWhere(window, func(_, _ string) bool { return true })
+}
+
+// zshEntryStart finds the first line that isn't a continuation of the one before it
+func zshEntryStart(window []byte) int {
+	return firstLineWhere(window, func(prev, _ string) bool { return !strings.HasSuffix(prev, "\\") })
+}
+
+// bashEntryStart finds the first timestamp line if the history is timestamped,
+// otherwise the first line that doesn't continue the one before it
+func bashEntryStart(window []byte) int {
+	if bashTimestampRe.Match(window) {
+		return firstLineWhere(window, func(_, line string) bool { return bashTimestampRe.MatchString(line) })
+	}
+	return firstLineWhere(window, func(prev, _ string) bool { return !hasLineContinuation(prev) })
+}
+
+// fishEntryStart finds the first "- cmd:" line
+func fishEntryStart(window []byte) int {
+	return firstLineWhere(window, func(_, line string) bool { return strings.HasPrefix(line, "- cmd:") })
+}
+
+// powerShellEntryStart finds the first line that doesn't continue a backtick-terminated line
+func powerShellEntryStart(window []byte) int {
+	return firstLineWhere(window, func(prev, _ string) bool { return !strings.HasSuffix(strings.TrimSuffix(prev, "\r"), "`") })
+}
+
+// tcshEntryStart finds the first timestamp line if the history is timestamped,
+// otherwise the first full line
+func tcshEntryStart(window []byte) int {
+	if tcshTimestampRe.Match(window) {
+		return firstLineWhere(window, func(_, line string) bool { return tcshTimestampRe.MatchString(line) })
+	}
+	return anyEntryStart(window)
+}
@@ -0,0 +1,215 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tailFormat describes how to write and read one history format
+type tailFormat struct {
+	name      string
+	entry     func(i int) string // The lines for the i-th entry, without the final newline
+	parse     func(io.Reader, int) ([]CommandEntry, error)
+	findStart func([]byte) int
+}
+
+// tailCommand makes up a command, now and then a long one so entries don't
+// all end up the same size
+func tailCommand(i int) string {
+	if i%17 == 0 {
+		return fmt.Sprintf("curl -s https://example.com/api?q=%s", strings.Repeat("x", 300+i%50))
+	}
+	return fmt.Sprintf("git commit -m 'change %d'", i)
+}
+
+var tailFormats = []tailFormat{
+	{
+		name: "zsh",
+		entry: func(i int) string {
+			if i%7 == 0 {
+				return fmt.Sprintf(": %d:0;for f in %d; do\\\n  echo $f\\\ndone", 1700000000+i, i)
+			}
+			return fmt.Sprintf(": %d:0;%s", 1700000000+i, tailCommand(i))
+		},
+		parse:     parseZshEntries,
+		findStart: zshEntryStart,
+	},
+	{
+		name: "fish",
+		entry: func(i int) string {
+			entry := fmt.Sprintf("- cmd: %s\n  when: %d", tailCommand(i), 1700000000+i)
+			if i%5 == 0 {
+				entry += fmt.Sprintf("\n  paths:\n    - file%d.txt", i)
+			}
+			return entry
+		},
+		parse:     parseFishEntries,
+		findStart: fishEntryStart,
+	},
+	{
+		name: "bash",
+		entry: func(i int) string {
+			switch {
+			case i%11 == 0:
+				return fmt.Sprintf("echo %d \\\n  continued", i)
+			case i%13 == 0:
+				return fmt.Sprintf("cat <<EOF > out%d.txt\nline one\nline two\nEOF", i)
+			}
+			return tailCommand(i)
+		},
+		parse:     parseBashEntries,
+		findStart: bashEntryStart,
+	},
+	{
+		name: "bash with timestamps",
+		entry: func(i int) string {
+			if i%9 == 0 {
+				return fmt.Sprintf("#%d\nfor i in %d; do\n  echo $i\ndone", 1700000000+i, i)
+			}
+			return fmt.Sprintf("#%d\n%s", 1700000000+i, tailCommand(i))
+		},
+		parse:     parseBashEntries,
+		findStart: bashEntryStart,
+	},
+	{
+		name: "bash with some timestamps",
+		entry: func(i int) string {
+			if i%3 == 0 {
+				return fmt.Sprintf("#%d\n%s", 1700000000+i, tailCommand(i))
+			}
+			return tailCommand(i)
+		},
+		parse:     parseBashEntries,
+		findStart: bashEntryStart,
+	},
+}
+
+// writeHistory writes entries to a file in dir until it has at least lines lines
+func writeHistory(tb testing.TB, dir string, f tailFormat, lines int) string {
+	tb.Helper()
+
+	path := filepath.Join(dir, strings.ReplaceAll(f.name, " ", "_"))
+	file, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for i, written := 1, 0; written < lines; i++ {
+		entry := f.entry(i)
+		written += strings.Count(entry, "\n") + 1
+		fmt.Fprintln(w, entry)
+	}
+	if err := w.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// fullParse parses the whole file from the start, keeping the last limit entries
+func fullParse(tb testing.TB, path string, f tailFormat, limit int) []CommandEntry {
+	tb.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+
+	entries, err := f.parse(file, limit)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return entries
+}
+
+func TestReadTailMatchesFullParse(t *testing.T) {
+	// Big enough that small limits only read the last chunk or two
+	const lines = 40000
+
+	for _, f := range tailFormats {
+		path := writeHistory(t, t.TempDir(), f, lines)
+		for _, limit := range []int{1, 10, 500, 1234, 5000, 100000} {
+			t.Run(fmt.Sprintf("%s/%d", f.name, limit), func(t *testing.T) {
+				got, err := readTail(context.Background(), path, limit, f.parse, f.findStart)
+				if err != nil {
+					t.Fatalf("readTail: %v", err)
+				}
+				want := fullParse(t, path, f, limit)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("readTail returned %d entries that differ from a full parse's %d", len(got), len(want))
+					for i := range min(len(got), len(want)) {
+						if !reflect.DeepEqual(got[i], want[i]) {
+							t.Errorf("first difference at %d: got %q, want %q", i, got[i].Command, want[i].Command)
+							break
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestReadTailCancelled(t *testing.T) {
+	f := tailFormats[0]
+	path := writeHistory(t, t.TempDir(), f, 40000)
+
+	for _, limit := range []int{0, 10, 100000} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Cancel once parsing has started, as Ctrl+C would
+			var parsed bool
+			parse := func(r io.Reader, limit int) ([]CommandEntry, error) {
+				parsed = true
+				cancel()
+				return f.parse(r, limit)
+			}
+
+			entries, err := readTail(ctx, path, limit, parse, f.findStart)
+			if !errors.Is(err, context.Canceled) || entries != nil {
+				t.Fatalf("readTail = %d entries, %v; want context.Canceled", len(entries), err)
+			}
+			if !parsed {
+				t.Errorf("parsing never started")
+			}
+		})
+	}
+}
+
+// benchmarkParse compares a full parse with readTail on a history of about a million lines
+func benchmarkParse(b *testing.B, f tailFormat) {
+	const limit = 500
+	path := writeHistory(b, b.TempDir(), f, 1000000)
+
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fullParse(b, path, f, limit)
+		}
+	})
+	b.Run("tail", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := readTail(context.Background(), path, limit, f.parse, f.findStart); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkParseZshHistory(b *testing.B) {
+	benchmarkParse(b, tailFormats[0])
+}
+
+func BenchmarkParseBashHistory(b *testing.B) {
+	benchmarkParse(b, tailFormats[2])
+}
@@ -0,0 +1,72 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// xonshSession is the subset of a xonsh JSON history session file we care about
+type xonshSession struct {
+	Data struct {
+		Cmds []struct {
+			Inp string    `json:"inp"`
+			Ts  []float64 `json:"ts"`
+		} `json:"cmds"`
+	} `json:"data"`
+}
+
+// parseXonshHistory reads every JSON session file in xonsh's history directory
+// and merges them into a single time-ordered history
+func parseXonshHistory(ctx context.Context, historyDir string, limit int) ([]CommandEntry, error) {
+	files, err := filepath.Glob(filepath.Join(historyDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CommandEntry
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// xonsh rewrites session files while the shell runs, so skip any we catch half-written
+		var session xonshSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			continue
+		}
+
+		for _, c := range session.Data.Cmds {
+			cmd := strings.TrimSpace(c.Inp)
+			if cmd == "" {
+				continue
+			}
+
+			entry := CommandEntry{Command: cmd}
+			if len(c.Ts) > 0 && c.Ts[0] > 0 {
+				entry.Timestamp = time.UnixMilli(int64(c.Ts[0] * 1000))
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(files) > 0 && len(entries) == 0 {
+		return nil, fmt.Errorf("no readable xonsh sessions in %s", historyDir)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+
+	return keepLast(entries, limit), nil
+}
@@ -0,0 +1,57 @@
+package history
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseXonshHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  []parsed
+	}{
+		{
+			name: "sessions merged by time, half-written ones skipped",
+			want: []parsed{
+				{"ls -la", 1700000000},
+				{"make test", 1700000010},
+				{"git status", 1700000020},
+			},
+		},
+		{
+			name:  "limit keeps the most recent",
+			limit: 2,
+			want: []parsed{
+				{"make test", 1700000010},
+				{"git status", 1700000020},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseXonshHistory(context.Background(), filepath.Join("testdata", "xonsh"), tt.limit)
+			if err != nil {
+				t.Fatalf("parseXonshHistory: %v", err)
+			}
+			if got := summarize(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXonshHistoryNoReadableSessions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"data": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseXonshHistory(context.Background(), dir, 0); err == nil {
+		t.Error("expected an error when no session could be read")
+	}
+}
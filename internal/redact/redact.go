@@ -0,0 +1,258 @@
+package redact
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+// Rule detects one kind of sensitive value. If the pattern has a group named
+// "secret", only that group is replaced; otherwise the whole match is.
+type Rule struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Redaction records a single value that was removed from a command
+type Redaction struct {
+	Rule  string
+	Value string
+}
+
+// Redactor scrubs secrets and personal data from commands before they are sent anywhere
+type Redactor struct {
+	rules   []Rule
+	entropy bool
+}
+
+// minEntropyLength is the shortest token checked for high entropy
+const minEntropyLength = 20
+
+// minEntropyBits is the Shannon entropy per character above which a token looks random
+const minEntropyBits = 4.0
+
+// builtinRules are checked in order, so specific token formats win over the generic rules
+var builtinRules = []Rule{
+	{Name: "private-key", Pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`)},
+	{Name: "aws-access-key", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{Name: "gitlab-token", Pattern: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{Name: "anthropic-key", Pattern: regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`)},
+	{Name: "openai-key", Pattern: regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_-]{20,}`)},
+	{Name: "stripe-key", Pattern: regexp.MustCompile(`\b[rs]k_(?:live|test)_[A-Za-z0-9]{16,}\b`)},
+	{Name: "google-api-key", Pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{Name: "auth-header", Pattern: regexp.MustCompile(`(?i)(?:authorization:\s*(?:bearer|basic|token)?\s*|x-api-key:\s*|\bbearer\s+)(?P<secret>[^\s'"]+)`)},
+	{Name: "url-credentials", Pattern: regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@]+)@`)},
+	{Name: "password-flag", Pattern: regexp.MustCompile(`(?i)(?:--(?:(?:http|ftp|proxy)-)?(?:password|passwd|pass|token|api-key|apikey|secret|access-token|client-secret)(?:=|\s+))(?P<secret>'[^']*'|"[^"]*"|[^\s'"]+)`)},
+	// Short password flags only mean a password for some tools; psql's -p is its port
+	{Name: "password-flag", Pattern: regexp.MustCompile(`\b(?:mysql|mysqldump|mysqladmin|mariadb)\b[^|;&]*?\s-p(?P<secret>[^\s'"-][^\s]*|'[^']*'|"[^"]*")`)},
+	{Name: "password-flag", Pattern: regexp.MustCompile(`\b(?:(?:docker|podman|buildah|helm\s+registry)\s+login|sshpass|mongo|mongosh)\b[^|;&]*?\s-p(?:=|\s*)(?P<secret>'[^']*'|"[^"]*"|[^\s'"-][^\s'"]*)`)},
+	{Name: "password-flag", Pattern: regexp.MustCompile(`\b(?:redis-cli\b[^|;&]*?\s-a|sqlcmd\b[^|;&]*?\s-P)\s+(?P<secret>'[^']*'|"[^"]*"|[^\s'"]+)`)},
+	// curl and wget take "user:password" together
+	{Name: "password-flag", Pattern: regexp.MustCompile(`\b(?:curl|wget)\b[^|;&]*?\s(?:-u|-U|--user|--proxy-user)(?:=|\s*)['"]?[^\s:'"]*:(?P<secret>[^\s'"]+)`)},
+	// Names are matched in any case, and short keywords like AUTH only count as
+	// a whole part of the name, so GITHUB_AUTHOR and --author= are left alone
+	{Name: "env-secret", Pattern: regexp.MustCompile(`(?i)(?:^|[^\w-])(?:[A-Z0-9_]*(?:SECRET|TOKEN|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*|(?:[A-Z0-9]+_)*(?:AUTH|PASS)(?:_[A-Z0-9]+)*)=(?P<secret>'[^']*'|"[^"]*"|[^\s'"]+)`)},
+	// Followed by ":" it's an ssh remote like git@github.com:user/repo, not an address
+	{Name: "email", Pattern: regexp.MustCompile(`(?P<secret>\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,})(?:[^A-Za-z0-9.:-]|$)`)},
+	{Name: "internal-host", Pattern: regexp.MustCompile(`\b(?:[A-Za-z0-9-]+\.)+(?:internal|corp|lan|intranet)\b`)},
+	{Name: "private-ip", Pattern: regexp.MustCompile(`\b(?:10\.\d{1,3}|192\.168|172\.(?:1[6-9]|2\d|3[01]))\.\d{1,3}\.\d{1,3}\b`)},
+}
+
+// New creates a redactor with the built-in detectors plus any custom rules from the config
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	rules := append([]Rule(nil), builtinRules...)
+
+	for _, custom := range cfg.Rules {
+		pattern, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction rule %q: %v", custom.Name, err)
+		}
+		name := custom.Name
+		if name == "" {
+			name = "custom"
+		}
+		rules = append(rules, Rule{Name: name, Pattern: pattern, Replacement: custom.Replacement})
+	}
+
+	return &Redactor{rules: rules, entropy: !cfg.DisableEntropy}, nil
+}
+
+// Redact removes sensitive values from a command and reports what was removed
+func (r *Redactor) Redact(command string) (string, []Redaction) {
+	var redactions []Redaction
+
+	for _, rule := range r.rules {
+		command = replaceRule(command, rule, &redactions)
+	}
+
+	if r.entropy {
+		command = replaceHighEntropy(command, &redactions)
+	}
+
+	return command, redactions
+}
+
+// RedactAll redacts every command in the slice, returning a new slice
+func (r *Redactor) RedactAll(commands []string) []string {
+	redacted := make([]string, len(commands))
+	for i, cmd := range commands {
+		redacted[i], _ = r.Redact(cmd)
+	}
+	return redacted
+}
+
+// placeholder is what a redacted value is replaced with, so the AI can still
+// tell what kind of thing was there
+func placeholder(rule Rule) string {
+	if rule.Replacement != "" {
+		return rule.Replacement
+	}
+	return "<redacted:" + rule.Name + ">"
+}
+
+// replaceRule replaces every match of rule in s, or just its "secret" group if it has one
+func replaceRule(s string, rule Rule, redactions *[]Redaction) string {
+	matches := rule.Pattern.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	group := rule.Pattern.SubexpIndex("secret")
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if group > 0 && m[2*group] >= 0 {
+			start, end = m[2*group], m[2*group+1]
+		}
+		if start < last || start == end || strings.Contains(s[start:end], "<redacted:") {
+			continue
+		}
+
+		*redactions = append(*redactions, Redaction{Rule: rule.Name, Value: s[start:end]})
+		b.WriteString(s[last:start])
+		b.WriteString(placeholder(rule))
+		last = end
+	}
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+// entropyTokenRe finds runs of characters that API keys and tokens are made of
+var entropyTokenRe = regexp.MustCompile(`[A-Za-z0-9+/_=.-]{20,}`)
+
+// replaceHighEntropy replaces tokens that look randomly generated, which
+// catches secrets in formats the specific rules don't know about
+func replaceHighEntropy(s string, redactions *[]Redaction) string {
+	rule := Rule{Name: "high-entropy"}
+
+	return entropyTokenRe.ReplaceAllStringFunc(s, func(token string) string {
+		if len(token) < minEntropyLength || strings.Contains(token, "redacted:") || looksLikePath(token) {
+			return token
+		}
+		if shannonEntropy(token) < minEntropyBits || !hasMixedClasses(token) {
+			return token
+		}
+
+		*redactions = append(*redactions, Redaction{Rule: rule.Name, Value: token})
+		return placeholder(rule)
+	})
+}
+
+// looksLikePath reports whether a token is a file path or domain rather than a secret
+func looksLikePath(token string) bool {
+	if strings.HasPrefix(token, "/") || strings.HasPrefix(token, ".") || strings.Count(token, "/") > 1 {
+		return true
+	}
+	return strings.Count(token, ".") > 1 || strings.Count(token, "-") > 2
+}
+
+// hasMixedClasses reports whether a token mixes letters and digits, as random tokens do
+func hasMixedClasses(token string) bool {
+	var letter, digit bool
+	for _, c := range token {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			letter = true
+		}
+	}
+	return letter && digit
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+
+	total := float64(len(s))
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// Preview describes how a single command would be changed before being sent
+type Preview struct {
+	Original   string
+	Redacted   string
+	Redactions []Redaction
+}
+
+// PreviewAll returns a preview for every command that would be changed by redaction
+func (r *Redactor) PreviewAll(commands []string) []Preview {
+	var previews []Preview
+	seen := make(map[string]bool)
+
+	for _, cmd := range commands {
+		if seen[cmd] {
+			continue
+		}
+		seen[cmd] = true
+
+		redacted, redactions := r.Redact(cmd)
+		if len(redactions) > 0 {
+			previews = append(previews, Preview{Original: cmd, Redacted: redacted, Redactions: redactions})
+		}
+	}
+
+	return previews
+}
+
+// Summarize counts redactions by rule name, sorted by rule name
+func Summarize(previews []Preview) []RuleCount {
+	counts := make(map[string]int)
+	for _, p := range previews {
+		for _, r := range p.Redactions {
+			counts[r.Rule]++
+		}
+	}
+
+	summary := make([]RuleCount, 0, len(counts))
+	for rule, count := range counts {
+		summary = append(summary, RuleCount{Rule: rule, Count: count})
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Rule < summary[j].Rule })
+	return summary
+}
+
+// RuleCount is how many values a rule redacted
+type RuleCount struct {
+	Rule  string
+	Count int
+}
@@ -0,0 +1,107 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/jasonlovesdoggo/roastme/internal/config"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		// Password flags
+		{"long password flag", "mysql --password=hunter2 -u root", "mysql --password=<redacted:password-flag> -u root"},
+		{"mysql short flag", "mysql -u root -phunter2 db", "mysql -u root -p<redacted:password-flag> db"},
+		{"docker login", "docker login -u me -p hunter2 registry.example.com", "docker login -u me -p <redacted:password-flag> registry.example.com"},
+		{"podman login", "podman login -p=hunter2 quay.io", "podman login -p=<redacted:password-flag> quay.io"},
+		{"sshpass", "sshpass -p hunter2 ssh deploy@web1", "sshpass -p <redacted:password-flag> ssh deploy@web1"},
+		{"sshpass attached", "sshpass -phunter2 ssh deploy@web1", "sshpass -p<redacted:password-flag> ssh deploy@web1"},
+		{"mongo", "mongosh -u admin -p hunter2", "mongosh -u admin -p <redacted:password-flag>"},
+		{"redis-cli", "redis-cli -h cache -a secret ping", "redis-cli -h cache -a <redacted:password-flag> ping"},
+		{"curl user", "curl -u admin:hunter2 https://example.com", "curl -u admin:<redacted:password-flag> https://example.com"},
+		{"curl long user", "curl --user 'admin:hunter2' https://example.com", "curl --user 'admin:<redacted:password-flag>' https://example.com"},
+		{"wget user", "wget --user=admin:hunter2 https://example.com/f", "wget --user=admin:<redacted:password-flag> https://example.com/f"},
+		{"wget http password", "wget --http-password=hunter2 https://example.com/f", "wget --http-password=<redacted:password-flag> https://example.com/f"},
+
+		// Environment variables
+		{"env token", "GITHUB_TOKEN=abc123 gh pr list", "GITHUB_TOKEN=<redacted:env-secret> gh pr list"},
+		{"env pass part", "DB_PASS=hunter2 ./migrate", "DB_PASS=<redacted:env-secret> ./migrate"},
+		{"env auth part", "export BASIC_AUTH_HEADER=abc", "export BASIC_AUTH_HEADER=<redacted:env-secret>"},
+		{"lowercase password variable", "export db_password=hunter2", "export db_password=<redacted:env-secret>"},
+		{"lowercase api key", "api_key=abc123 ./deploy.sh", "api_key=<redacted:env-secret> ./deploy.sh"},
+		{"mixed case token", "export GitHub_Token=abc123", "export GitHub_Token=<redacted:env-secret>"},
+		{"lowercase auth", "export auth=x", "export auth=<redacted:env-secret>"},
+
+		// Not secrets
+		{"port flags", "docker run -p 8080:80 nginx", "docker run -p 8080:80 nginx"},
+		{"psql port", "psql -h db -p 5432 -U app", "psql -h db -p 5432 -U app"},
+		{"git author flag", "git log --author=jason", "git log --author=jason"},
+		{"git commit author", "git commit --author='Jane Doe' -m fix", "git commit --author='Jane Doe' -m fix"},
+		{"author variable", "GITHUB_AUTHOR=jane make release", "GITHUB_AUTHOR=jane make release"},
+		{"oauth variable", "OAUTH_CALLBACK=http://localhost:3000 npm start", "OAUTH_CALLBACK=http://localhost:3000 npm start"},
+		{"passthrough variable", "PASSTHROUGH=1 make", "PASSTHROUGH=1 make"},
+		{"flag, not variable", "tool --AUTH=x", "tool --AUTH=x"},
+		{"lowercase author variable", "export github_author=jane", "export github_author=jane"},
+		{"token count flag", "llm --max-tokens=100", "llm --max-tokens=100"},
+		{"curl without password", "curl -u admin https://example.com", "curl -u admin https://example.com"},
+	}
+
+	r, err := New(config.RedactionConfig{DisableEntropy: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := r.Redact(tt.command); got != tt.want {
+				t.Errorf("Redact(%q)\n got  %q\n want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactHighEntropy(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		// Random tokens in formats no specific rule knows about
+		{"custom header token", `curl -H "X-Service-Key: q8Zr2LmX7vTa9KpW4nYc3BdF" https://api.example.com`, `curl -H "X-Service-Key: <redacted:high-entropy>" https://api.example.com`},
+		{"bare argument", "vault login hvs.CAESIJq3xY8mZr2kL9vTnW4pB7d", "vault login <redacted:high-entropy>"},
+
+		// Long, but not random enough or shaped like something else
+		{"git sha", "git checkout 3f2a9c1e8b7d6a5f4e3d2c1b0a9f8e7d6c5b4a3f", "git checkout 3f2a9c1e8b7d6a5f4e3d2c1b0a9f8e7d6c5b4a3f"},
+		{"long word", "echo supercalifragilisticexpialidocious", "echo supercalifragilisticexpialidocious"},
+		{"path", "cat /var/log/nginx/access.log.2024-01-15", "cat /var/log/nginx/access.log.2024-01-15"},
+		{"domain", "dig api.us-east-1.example.amazonaws.com", "dig api.us-east-1.example.amazonaws.com"},
+		{"uuid", "kubectl delete pod 123e4567-e89b-12d3-a456-426614174000", "kubectl delete pod 123e4567-e89b-12d3-a456-426614174000"},
+		{"short token", "echo aB3dE5fG7h", "echo aB3dE5fG7h"},
+	}
+
+	r, err := New(config.RedactionConfig{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := r.Redact(tt.command); got != tt.want {
+				t.Errorf("Redact(%q)\n got  %q\n want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+
+	// With the detector off, only the specific rules apply
+	off, err := New(config.RedactionConfig{DisableEntropy: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	command := tests[0].command
+	if got, _ := off.Redact(command); got != command {
+		t.Errorf("with entropy disabled, Redact(%q) = %q", command, got)
+	}
+}
@@ -0,0 +1,643 @@
+// Package shell parses history entries the way a shell would, so analysis
+// sees the command that actually ran rather than the first word typed
+package shell
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Command is one simple command from a history entry
+type Command struct {
+	Name       string   // The program that ran, e.g. "git" for "sudo FOO=1 time git push"
+	Subcommand string   // What a tool like git or docker was asked to do, e.g. "push"
+	Args       []string // Arguments after the program, unquoted
+	Flags      []string // The arguments that start with a dash
+	Wrappers   []string // Commands it ran under, e.g. "sudo" or "time"
+	Assigns    []string // Variables set just for it, e.g. "FOO=1"
+	Redirects  []string // Redirections, e.g. "2>&1" or "> out.log"
+	Stage      int      // Position in its pipeline, 0 for the first or only command
+	Depth      int      // How many subshells and substitutions it is nested in
+	Substitute bool     // Whether it runs inside $(...), backticks or <(...) to make another command's arguments
+}
+
+// Line is a whole history entry, parsed
+type Line struct {
+	Text     string    // The entry as it was typed
+	Commands []Command // Every simple command, in the order they appear
+	Pipes    int       // How many pipes there are
+	Chains   int       // How many times commands are chained with &&, ||, ; or &
+	Depth    int       // Deepest nesting of subshells and substitutions
+	Valid    bool      // Whether it parsed; if not, Commands are a best guess
+}
+
+// First returns the first command that isn't inside a substitution, which is
+// usually the one the entry is about. Subshells count, so "(cd x && make)"
+// is about cd.
+func (l Line) First() (Command, bool) {
+	for _, c := range l.Commands {
+		if !c.Substitute {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// Has reports whether the command was given any of the arguments
+func (c Command) Has(args ...string) bool {
+	for _, arg := range c.Args {
+		for _, want := range args {
+			if arg == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sudo reports whether the command ran with raised privileges
+func (c Command) Sudo() bool {
+	for _, w := range c.Wrappers {
+		if w == "sudo" || w == "doas" {
+			return true
+		}
+	}
+	return false
+}
+
+// Operands returns the arguments that aren't flags
+func (c Command) Operands() []string {
+	var operands []string
+	for _, arg := range c.Args {
+		if !isFlag(arg) {
+			operands = append(operands, arg)
+		}
+	}
+	return operands
+}
+
+// wrapper describes a command that runs another: which of its flags take a
+// value, and how many arguments come before the command it runs
+type wrapper struct {
+	valueFlags  []string
+	positionals int
+}
+
+// wrappers are the commands that only set up the real one
+var wrappers = map[string]wrapper{
+	"sudo":       {valueFlags: []string{"-u", "-g", "-C", "-D", "-h", "-p", "-r", "-t", "-U", "-T"}},
+	"doas":       {valueFlags: []string{"-u", "-C"}},
+	"time":       {valueFlags: []string{"-f", "-o"}},
+	"env":        {valueFlags: []string{"-u", "-C", "-S"}},
+	"nohup":      {},
+	"nice":       {valueFlags: []string{"-n"}},
+	"ionice":     {valueFlags: []string{"-c", "-n", "-p"}},
+	"exec":       {valueFlags: []string{"-a"}},
+	"timeout":    {valueFlags: []string{"-k", "-s"}, positionals: 1},
+	"watch":      {valueFlags: []string{"-n", "-d"}},
+	"stdbuf":     {valueFlags: []string{"-i", "-o", "-e"}},
+	"caffeinate": {valueFlags: []string{"-t", "-w"}},
+}
+
+// subcommandTools are programs whose first operand says what they were asked
+// to do, so "git push" and "git log" are told apart
+var subcommandTools = map[string]bool{
+	"git": true, "docker": true, "podman": true, "kubectl": true, "helm": true,
+	"npm": true, "yarn": true, "pnpm": true, "pip": true, "pip3": true, "cargo": true,
+	"go": true, "apt": true, "apt-get": true, "brew": true, "dnf": true, "yum": true,
+	"snap": true, "systemctl": true, "ufw": true, "gh": true, "terraform": true, "make": true,
+	"conda": true, "poetry": true, "rustup": true, "gcloud": true, "aws": true, "az": true,
+}
+
+// globalFlags are the flags that take a value before a tool's subcommand, so
+// "git -C repo push" is still a push
+var globalFlags = map[string][]string{
+	"git":       {"-C", "-c", "--git-dir", "--work-tree", "--namespace"},
+	"docker":    {"-H", "--host", "-c", "--context", "--config", "-l", "--log-level"},
+	"podman":    {"-c", "--connection", "--url"},
+	"kubectl":   {"-n", "--namespace", "--context", "--kubeconfig", "--cluster", "--user", "-s", "--server"},
+	"helm":      {"-n", "--namespace", "--kube-context", "--kubeconfig"},
+	"npm":       {"--prefix", "-w", "--workspace"},
+	"yarn":      {"--cwd"},
+	"pnpm":      {"-C", "--dir", "--filter", "-F"},
+	"make":      {"-C", "-f", "--directory", "--file", "--makefile", "-I"},
+	"cargo":     {"-C", "--config", "-Z"},
+	"go":        {"-C"},
+	"systemctl": {"-H", "--host", "-M", "--machine", "-t", "--type", "-p", "--property", "--state"},
+}
+
+// Parse parses one history entry
+func Parse(text string) Line {
+	return newParser().parse(text)
+}
+
+// ParseAll parses every history entry, reusing one parser
+func ParseAll(texts []string) []Line {
+	p := newParser()
+	lines := make([]Line, len(texts))
+	for i, text := range texts {
+		lines[i] = p.parse(text)
+	}
+	return lines
+}
+
+// parser turns history entries into Lines
+type parser struct {
+	syntax  *syntax.Parser
+	printer *syntax.Printer
+
+	// State for the entry being parsed
+	line   *Line
+	substs int // How many substitutions the walk is inside
+	stages map[*syntax.Stmt]int
+	timed  map[*syntax.Stmt]bool
+	stmtOf map[*syntax.CallExpr]*syntax.Stmt
+}
+
+// newParser returns a parser for bash, which most history reads as
+func newParser() *parser {
+	return &parser{
+		syntax:  syntax.NewParser(syntax.Variant(syntax.LangBash)),
+		printer: syntax.NewPrinter(),
+	}
+}
+
+// parse parses one entry, falling back to a plain tokenizer for anything the
+// shell grammar rejects, like fish syntax or an unterminated quote
+func (p *parser) parse(text string) Line {
+	line := Line{Text: text}
+
+	file, err := p.syntax.Parse(strings.NewReader(text), "")
+	if err != nil {
+		line.Commands = fallbackCommands(text)
+		line.Pipes = countOperators(text, "|")
+		line.Chains = countOperators(text, "&&", "||", ";")
+		return line
+	}
+
+	line.Valid = true
+	p.line = &line
+	p.substs = 0
+	p.stages = make(map[*syntax.Stmt]int)
+	p.timed = make(map[*syntax.Stmt]bool)
+	p.stmtOf = make(map[*syntax.CallExpr]*syntax.Stmt)
+
+	p.stmts(file.Stmts, 0)
+	return line
+}
+
+// stmts walks a list of statements at a nesting depth
+func (p *parser) stmts(stmts []*syntax.Stmt, depth int) {
+	if len(stmts) > 1 {
+		p.line.Chains += len(stmts) - 1
+	}
+	for _, stmt := range stmts {
+		p.walk(stmt, depth)
+	}
+}
+
+// walk collects the commands under a node, stepping into subshells and
+// substitutions one level deeper
+func (p *parser) walk(node syntax.Node, depth int) {
+	if depth > p.line.Depth {
+		p.line.Depth = depth
+	}
+
+	syntax.Walk(node, func(n syntax.Node) bool {
+		switch x := n.(type) {
+		case *syntax.Stmt:
+			if call, ok := x.Cmd.(*syntax.CallExpr); ok {
+				p.stmtOf[call] = x
+			}
+		case *syntax.TimeClause:
+			if x.Stmt != nil {
+				p.timed[x.Stmt] = true
+			}
+		case *syntax.BinaryCmd:
+			if x.Op == syntax.Pipe || x.Op == syntax.PipeAll {
+				p.line.Pipes++
+				p.pipeline(x)
+			} else {
+				p.line.Chains++
+			}
+		case *syntax.CallExpr:
+			p.call(x, depth)
+		case *syntax.Subshell:
+			p.stmts(x.Stmts, depth+1)
+			return false
+		case *syntax.Block:
+			p.stmts(x.Stmts, depth)
+			return false
+		case *syntax.CmdSubst:
+			p.substitution(x.Stmts, depth+1)
+			return false
+		case *syntax.ProcSubst:
+			p.substitution(x.Stmts, depth+1)
+			return false
+		}
+		return true
+	})
+}
+
+// substitution walks the statements of a command or process substitution
+func (p *parser) substitution(stmts []*syntax.Stmt, depth int) {
+	p.substs++
+	p.stmts(stmts, depth)
+	p.substs--
+}
+
+// pipeline numbers the stages of a pipeline, which parses as nested pipes
+// with the earlier stages on the left
+func (p *parser) pipeline(cmd *syntax.BinaryCmd) {
+	if _, done := p.stages[cmd.Y]; done {
+		return
+	}
+
+	var stages []*syntax.Stmt
+	var flatten func(stmt *syntax.Stmt)
+	flatten = func(stmt *syntax.Stmt) {
+		if b, ok := stmt.Cmd.(*syntax.BinaryCmd); ok && (b.Op == syntax.Pipe || b.Op == syntax.PipeAll) {
+			flatten(b.X)
+			flatten(b.Y)
+			return
+		}
+		stages = append(stages, stmt)
+	}
+	flatten(cmd.X)
+	flatten(cmd.Y)
+
+	for i, stmt := range stages {
+		p.stages[stmt] = i
+	}
+}
+
+// call adds a simple command, unwrapping sudo and friends
+func (p *parser) call(call *syntax.CallExpr, depth int) {
+	var c Command
+	c.Depth = depth
+	c.Substitute = p.substs > 0
+
+	for _, assign := range call.Assigns {
+		if assign.Name == nil {
+			continue
+		}
+		value := ""
+		if assign.Value != nil {
+			value = p.word(assign.Value)
+		}
+		c.Assigns = append(c.Assigns, assign.Name.Value+"="+value)
+	}
+
+	words := make([]string, len(call.Args))
+	for i, w := range call.Args {
+		words[i] = p.word(w)
+	}
+
+	if stmt := p.stmtOf[call]; stmt != nil {
+		c.Stage = p.stages[stmt]
+		if p.timed[stmt] {
+			c.Wrappers = append(c.Wrappers, "time")
+		}
+		for _, r := range stmt.Redirs {
+			c.Redirects = append(c.Redirects, p.redirect(r))
+		}
+	}
+
+	if len(words) == 0 {
+		// A bare assignment runs nothing
+		return
+	}
+
+	unwrap(&c, words)
+	p.line.Commands = append(p.line.Commands, c)
+}
+
+// unwrap finds the command that runs under any wrappers and fills in its
+// name, arguments, flags and subcommand
+func unwrap(c *Command, words []string) {
+	for {
+		name := filepath.Base(words[0])
+		w, ok := wrappers[name]
+		if !ok {
+			break
+		}
+
+		rest := words[1:]
+		positionals := w.positionals
+	args:
+		for len(rest) > 0 {
+			arg := rest[0]
+			switch {
+			case arg == "--":
+				rest = rest[1:]
+				break args
+			case isAssign(arg):
+				c.Assigns = append(c.Assigns, arg)
+			case isFlag(arg):
+				if contains(w.valueFlags, arg) && len(rest) > 1 {
+					rest = rest[1:]
+				}
+			case positionals > 0:
+				positionals--
+			default:
+				break args
+			}
+			rest = rest[1:]
+		}
+
+		if len(rest) == 0 {
+			// "sudo -i" runs nothing but sudo itself
+			break
+		}
+		c.Wrappers = append(c.Wrappers, name)
+		words = rest
+	}
+
+	c.Name = filepath.Base(words[0])
+	c.Args = words[1:]
+
+	skip := globalFlags[c.Name]
+	for i := 0; i < len(c.Args); i++ {
+		arg := c.Args[i]
+		if isFlag(arg) {
+			c.Flags = append(c.Flags, arg)
+			if c.Subcommand == "" && contains(skip, arg) {
+				i++
+			}
+			continue
+		}
+		if c.Subcommand == "" && subcommandTools[c.Name] {
+			c.Subcommand = arg
+		}
+	}
+}
+
+// word returns a word's value as the command would see it, with quotes
+// removed. Expansions like $HOME or $(pwd) are kept as they were typed.
+func (p *parser) word(w *syntax.Word) string {
+	var b strings.Builder
+	for _, part := range w.Parts {
+		p.wordPart(&b, part, false)
+	}
+	return b.String()
+}
+
+// wordPart writes one part of a word, unquoted
+func (p *parser) wordPart(b *strings.Builder, part syntax.WordPart, quoted bool) {
+	switch x := part.(type) {
+	case *syntax.Lit:
+		b.WriteString(unescape(x.Value, quoted))
+	case *syntax.SglQuoted:
+		b.WriteString(x.Value)
+	case *syntax.DblQuoted:
+		for _, inner := range x.Parts {
+			p.wordPart(b, inner, true)
+		}
+	default:
+		var buf bytes.Buffer
+		if err := p.printer.Print(&buf, part); err == nil {
+			b.Write(buf.Bytes())
+		}
+	}
+}
+
+// redirect formats a redirection like "2>&1" or "> out.log"
+func (p *parser) redirect(r *syntax.Redirect) string {
+	n := ""
+	if r.N != nil {
+		n = r.N.Value
+	}
+	target := ""
+	if r.Word != nil {
+		target = p.word(r.Word)
+	}
+	if r.Op == syntax.DplOut || r.Op == syntax.DplIn {
+		return n + r.Op.String() + target
+	}
+	return strings.TrimSpace(n + r.Op.String() + " " + target)
+}
+
+// unescape removes the backslashes the shell would. Inside double quotes only
+// a few characters can be escaped.
+func unescape(s string, quoted bool) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (!quoted || strings.IndexByte("$`\"\\\n", s[i+1]) >= 0) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// redirectOps are the redirection operators the fallback tokenizer knows,
+// longest first
+var redirectOps = []string{"&>>", "&>", "<<<", ">>", ">|", ">&", "<<", "<&", "<>", ">", "<"}
+
+// redirectOp returns the redirection operator text starts with, if any. <( and
+// >( start process substitutions instead.
+func redirectOp(text string) string {
+	for _, op := range redirectOps {
+		if strings.HasPrefix(text, op) {
+			if (op == "<" || op == ">") && strings.HasPrefix(text[1:], "(") {
+				return ""
+			}
+			return op
+		}
+	}
+	return ""
+}
+
+// formatRedirect joins a redirection's operator and target the way the parser
+// does: "2>&1", but "> out.log"
+func formatRedirect(op, target string) string {
+	if strings.HasSuffix(op, ">&") || strings.HasSuffix(op, "<&") {
+		return op + target
+	}
+	return strings.TrimSpace(op + " " + target)
+}
+
+// fallbackCommands splits an entry the shell grammar rejected into commands
+// at unquoted operators, respecting quotes as far as they go
+func fallbackCommands(text string) []Command {
+	var commands []Command
+	var words, redirects []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	stage := 0
+	// op is the operator the current word started with, if it's a redirection,
+	// and pending one still waiting for its target in the next word
+	var op, pending string
+
+	endWord := func() {
+		if !inWord {
+			return
+		}
+		w := word.String()
+		word.Reset()
+		inWord = false
+
+		switch {
+		case op != "" && len(w) == len(op):
+			pending = op
+		case op != "":
+			redirects = append(redirects, formatRedirect(op, w[len(op):]))
+		case pending != "":
+			redirects = append(redirects, formatRedirect(pending, w))
+			pending = ""
+		default:
+			words = append(words, w)
+		}
+		op = ""
+	}
+	endCommand := func(nextStage int) {
+		endWord()
+		if pending != "" {
+			redirects = append(redirects, pending)
+			pending = ""
+		}
+		if len(words) > 0 {
+			var c Command
+			c.Stage = stage
+			// Leading assignments only set up the command
+			for len(words) > 0 && isAssign(words[0]) {
+				c.Assigns = append(c.Assigns, words[0])
+				words = words[1:]
+			}
+			if len(words) > 0 {
+				c.Redirects = redirects
+				unwrap(&c, words)
+				commands = append(commands, c)
+			}
+		}
+		words, redirects = nil, nil
+		stage = nextStage
+	}
+
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else if ch == '\\' && quote == '"' && i+1 < len(text) {
+				i++
+				word.WriteByte(text[i])
+			} else {
+				word.WriteByte(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inWord = true
+		case ch == '\\' && i+1 < len(text):
+			i++
+			word.WriteByte(text[i])
+			inWord = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			endWord()
+		case ch == '|' && i+1 < len(text) && text[i+1] == '|', ch == '&' && i+1 < len(text) && text[i+1] == '&':
+			i++
+			endCommand(0)
+		case ch == '|':
+			// |& pipes stderr too
+			if i+1 < len(text) && text[i+1] == '&' {
+				i++
+			}
+			endCommand(stage + 1)
+		case redirectOp(text[i:]) != "":
+			// A redirection, numbered by any digits typed right before it,
+			// so the & in 2>&1 or &> doesn't end the command
+			fd := word.String()
+			if op != "" || ch == '&' || strings.Trim(fd, "0123456789") != "" {
+				endWord()
+				fd = ""
+			}
+			redirect := redirectOp(text[i:])
+			op = fd + redirect
+			word.WriteString(redirect)
+			inWord = true
+			i += len(redirect) - 1
+		case ch == ';' || ch == '&':
+			endCommand(0)
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	endCommand(0)
+	return commands
+}
+
+// countOperators counts the operators outside quotes in an entry the shell
+// grammar rejected
+func countOperators(text string, operators ...string) int {
+	count := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'':
+			quote = ch
+		case ch == '"':
+			quote = ch
+		case ch == '\\':
+			i++
+		case strings.HasPrefix(text[i:], "&&"), strings.HasPrefix(text[i:], "||"):
+			if contains(operators, text[i:i+2]) {
+				count++
+			}
+			i++
+		case redirectOp(text[i:]) != "":
+			// The & in 2>&1 and the | in >| aren't operators
+			i += len(redirectOp(text[i:])) - 1
+		case ch == '|' || ch == ';' || ch == '&':
+			if contains(operators, string(ch)) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// isAssign reports whether a word sets a variable, like FOO=1
+func isAssign(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && (i == 0 || !(r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// isFlag reports whether an argument is a flag rather than an operand
+func isFlag(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-' && arg != "--"
+}
+
+// contains reports whether a list has an item
+func contains(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
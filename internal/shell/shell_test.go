@@ -0,0 +1,239 @@
+package shell
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct {
+		text       string
+		name       string
+		subcommand string
+		wrappers   []string
+		assigns    []string
+		args       []string
+	}{
+		{text: "sudo apt install x", name: "apt", subcommand: "install", wrappers: []string{"sudo"}, args: []string{"install", "x"}},
+		{text: "sudo -u www-data php artisan migrate", name: "php", wrappers: []string{"sudo"}, args: []string{"artisan", "migrate"}},
+		{text: "sudo -E FOO=1 git push", name: "git", subcommand: "push", wrappers: []string{"sudo"}, assigns: []string{"FOO=1"}, args: []string{"push"}},
+		{text: "env -u HOME PATH=/bin make test", name: "make", subcommand: "test", wrappers: []string{"env"}, assigns: []string{"PATH=/bin"}, args: []string{"test"}},
+		{text: "time go test ./...", name: "go", subcommand: "test", wrappers: []string{"time"}, args: []string{"test", "./..."}},
+		{text: "/usr/bin/time -f %e make", name: "make", wrappers: []string{"time"}, args: []string{}},
+		{text: "sudo time nice -n 5 cargo build", name: "cargo", subcommand: "build", wrappers: []string{"sudo", "time", "nice"}, args: []string{"build"}},
+		{text: "timeout 5s curl example.com", name: "curl", wrappers: []string{"timeout"}, args: []string{"example.com"}},
+		{text: "FOO=1 BAR= git -C repo push", name: "git", subcommand: "push", assigns: []string{"FOO=1", "BAR="}, args: []string{"-C", "repo", "push"}},
+		{text: "sudo -i", name: "sudo", args: []string{"-i"}},
+		{text: "sudo -- rm -rf /tmp/x", name: "rm", wrappers: []string{"sudo"}, args: []string{"-rf", "/tmp/x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			c, ok := Parse(tt.text).First()
+			if !ok {
+				t.Fatalf("no command in %q", tt.text)
+			}
+			if c.Name != tt.name || c.Subcommand != tt.subcommand {
+				t.Errorf("command = %q %q, want %q %q", c.Name, c.Subcommand, tt.name, tt.subcommand)
+			}
+			if !reflect.DeepEqual(c.Wrappers, tt.wrappers) {
+				t.Errorf("Wrappers = %q, want %q", c.Wrappers, tt.wrappers)
+			}
+			if !reflect.DeepEqual(c.Assigns, tt.assigns) {
+				t.Errorf("Assigns = %q, want %q", c.Assigns, tt.assigns)
+			}
+			if !reflect.DeepEqual(c.Args, tt.args) {
+				t.Errorf("Args = %q, want %q", c.Args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text   string
+		names  []string // Every command, in order
+		first  string
+		pipes  int
+		chains int
+		depth  int
+		valid  bool
+	}{
+		{text: "git status", names: []string{"git"}, first: "git", valid: true},
+		{text: "cat log | grep err | wc -l", names: []string{"cat", "grep", "wc"}, first: "cat", pipes: 2, valid: true},
+		{text: `grep "a|b" file`, names: []string{"grep"}, first: "grep", valid: true},
+		{text: `echo 'x && y; z'`, names: []string{"echo"}, first: "echo", valid: true},
+		{text: "make && make install || echo failed", names: []string{"make", "make", "echo"}, first: "make", chains: 2, valid: true},
+		{text: "cd x; ls", names: []string{"cd", "ls"}, first: "cd", chains: 1, valid: true},
+		{text: "echo $(git rev-parse HEAD)", names: []string{"echo", "git"}, first: "echo", depth: 1, valid: true},
+		{text: "kill $(pgrep -f $(whoami))", names: []string{"kill", "pgrep", "whoami"}, first: "kill", depth: 2, valid: true},
+		{text: "echo `date`", names: []string{"echo", "date"}, first: "echo", depth: 1, valid: true},
+		{text: "diff <(ls a) <(ls b)", names: []string{"diff", "ls", "ls"}, first: "diff", depth: 1, valid: true},
+		{text: "(cd x && make)", names: []string{"cd", "make"}, first: "cd", chains: 1, depth: 1, valid: true},
+		{text: "(cd x && make) | tee log", names: []string{"cd", "make", "tee"}, first: "cd", pipes: 1, chains: 1, depth: 1, valid: true},
+		{text: "{ make; make test; }", names: []string{"make", "make"}, first: "make", chains: 1, valid: true},
+		{text: "FOO=1", valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			line := Parse(tt.text)
+
+			var names []string
+			for _, c := range line.Commands {
+				names = append(names, c.Name)
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("commands = %q, want %q", names, tt.names)
+			}
+
+			first, ok := line.First()
+			if ok != (tt.first != "") || first.Name != tt.first {
+				t.Errorf("First() = %q, %v; want %q", first.Name, ok, tt.first)
+			}
+			if line.Pipes != tt.pipes || line.Chains != tt.chains || line.Depth != tt.depth || line.Valid != tt.valid {
+				t.Errorf("pipes, chains, depth, valid = %d, %d, %d, %v; want %d, %d, %d, %v",
+					line.Pipes, line.Chains, line.Depth, line.Valid, tt.pipes, tt.chains, tt.depth, tt.valid)
+			}
+		})
+	}
+}
+
+func TestPipelineStages(t *testing.T) {
+	line := Parse("cat log | grep -v debug | sort && echo done")
+
+	var stages []int
+	for _, c := range line.Commands {
+		stages = append(stages, c.Stage)
+	}
+	if want := []int{0, 1, 2, 0}; !reflect.DeepEqual(stages, want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	tests := []struct {
+		text      string
+		names     []string
+		stages    []int
+		args      [][]string
+		redirects [][]string
+		pipes     int
+		chains    int
+	}{
+		// fish syntax
+		{
+			text:   "for f in *.go; echo $f; end",
+			names:  []string{"for", "echo", "end"},
+			stages: []int{0, 0, 0},
+			args:   [][]string{{"f", "in", "*.go"}, {"$f"}, {}},
+			chains: 2,
+		},
+		// An unterminated quote keeps the rest of the entry in one word
+		{
+			text:   `git commit -m "wip | more`,
+			names:  []string{"git"},
+			stages: []int{0},
+			args:   [][]string{{"commit", "-m", "wip | more"}},
+		},
+		{
+			text:   `sudo FOO=1 cat 'a b' | grep "x|y" && echo \; done; fi`,
+			names:  []string{"cat", "grep", "echo", "fi"},
+			stages: []int{0, 1, 0, 0},
+			args:   [][]string{{"a b"}, {"x|y"}, {";", "done"}, {}},
+			pipes:  1,
+			chains: 2,
+		},
+		{
+			text:   "ls |& less; fi",
+			names:  []string{"ls", "less", "fi"},
+			stages: []int{0, 1, 0},
+			args:   [][]string{{}, {}, {}},
+			pipes:  1,
+			chains: 1,
+		},
+		// The & in a redirection doesn't end the command
+		{
+			text:      `make 2>&1 | tee log; echo "x`,
+			names:     []string{"make", "tee", "echo"},
+			stages:    []int{0, 1, 0},
+			args:      [][]string{{}, {"log"}, {"x"}},
+			redirects: [][]string{{"2>&1"}, nil, nil},
+			pipes:     1,
+			chains:    1,
+		},
+		{
+			text:      `./build.sh &> build.log && tail -n 5 build.log; fi`,
+			names:     []string{"build.sh", "tail", "fi"},
+			stages:    []int{0, 0, 0},
+			args:      [][]string{{}, {"-n", "5", "build.log"}, {}},
+			redirects: [][]string{{"&> build.log"}, nil, nil},
+			chains:    2,
+		},
+		{
+			text:      `sort <&3 >| out 2>err.log >&2 x; echo 'y`,
+			names:     []string{"sort", "echo"},
+			stages:    []int{0, 0},
+			args:      [][]string{{"x"}, {"y"}},
+			redirects: [][]string{{"<&3", ">| out", "2> err.log", ">&2"}, nil},
+			chains:    1,
+		},
+		{
+			text:      "echo hi>out 3>>more; done",
+			names:     []string{"echo", "done"},
+			stages:    []int{0, 0},
+			args:      [][]string{{"hi"}, {}},
+			redirects: [][]string{{"> out", "3>> more"}, nil},
+			chains:    1,
+		},
+		// Escaped quotes inside double quotes don't close them
+		{
+			text:   `echo "a \" | b; c`,
+			names:  []string{"echo"},
+			stages: []int{0},
+			args:   [][]string{{`a " | b; c`}},
+		},
+		{
+			text:   `grep "\"|\"" log | wc -l; fi`,
+			names:  []string{"grep", "wc", "fi"},
+			stages: []int{0, 1, 0},
+			args:   [][]string{{`"|"`, "log"}, {"-l"}, {}},
+			pipes:  1,
+			chains: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			line := Parse(tt.text)
+			if line.Valid {
+				t.Fatalf("%q parsed as shell, want the fallback tokenizer", tt.text)
+			}
+
+			var names []string
+			var stages []int
+			var args [][]string
+			for _, c := range line.Commands {
+				names = append(names, c.Name)
+				stages = append(stages, c.Stage)
+				args = append(args, append([]string{}, c.Args...))
+			}
+			if !reflect.DeepEqual(names, tt.names) || !reflect.DeepEqual(stages, tt.stages) || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("commands = %q, stages %v, args %q\nwant %q, stages %v, args %q", names, stages, args, tt.names, tt.stages, tt.args)
+			}
+			for i, want := range tt.redirects {
+				if i < len(line.Commands) && !reflect.DeepEqual(line.Commands[i].Redirects, want) {
+					t.Errorf("%s redirects = %q, want %q", line.Commands[i].Name, line.Commands[i].Redirects, want)
+				}
+			}
+			if line.Pipes != tt.pipes || line.Chains != tt.chains {
+				t.Errorf("pipes, chains = %d, %d; want %d, %d", line.Pipes, line.Chains, tt.pipes, tt.chains)
+			}
+		})
+	}
+
+	// sudo is unwrapped and its assignment kept
+	if c, _ := Parse(tests[2].text).First(); !reflect.DeepEqual(c.Wrappers, []string{"sudo"}) || !reflect.DeepEqual(c.Assigns, []string{"FOO=1"}) {
+		t.Errorf("fallback sudo: Wrappers = %q, Assigns = %q", c.Wrappers, c.Assigns)
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/jasonlovesdoggo/roastme/internal/ai"
 	"github.com/jasonlovesdoggo/roastme/internal/config"
+	"github.com/jasonlovesdoggo/roastme/internal/redact"
 )
 
 var (
@@ -57,10 +59,12 @@ var (
 
 // Spinner represents a loading spinner
 type Spinner struct {
-	model  spinner.Model
-	text   string
-	active bool
-	done   chan struct{}
+	model    spinner.Model
+	text     string
+	active   bool
+	done     chan struct{}
+	finished chan struct{}
+	onQuit   func()
 }
 
 // NewSpinner creates a new spinner with the given text
@@ -69,18 +73,26 @@ func NewSpinner(text string) *Spinner {
 	s.Spinner = spinner.Line
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("#61AFEF"))
 	return &Spinner{
-		model:  s,
-		text:   text,
-		active: false,
-		done:   make(chan struct{}),
+		model:    s,
+		text:     text,
+		active:   false,
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 }
 
+// OnQuit sets a function to call when the user presses the spinner's quit key.
+// The spinner takes over the terminal, so Ctrl+C arrives here rather than as a signal.
+func (s *Spinner) OnQuit(fn func()) {
+	s.onQuit = fn
+}
+
 // Start starts the spinner
 func (s *Spinner) Start() {
 	s.active = true
 	go func() {
-		p := tea.NewProgram(spinnerModel{s.model, s.text})
+		defer close(s.finished)
+		p := tea.NewProgram(spinnerModel{s.model, s.text, s.onQuit})
 		go func() {
 			<-s.done
 			p.Quit()
@@ -96,6 +108,8 @@ func (s *Spinner) Stop() {
 	if s.active {
 		s.active = false
 		close(s.done)
+		// Wait for the last frame so it can't be drawn over what comes next
+		<-s.finished
 		// Clear the spinner line
 		fmt.Print("\033[2K\r")
 	}
@@ -105,6 +119,7 @@ func (s *Spinner) Stop() {
 type spinnerModel struct {
 	spinner spinner.Model
 	text    string
+	onQuit  func()
 }
 
 func (m spinnerModel) Init() tea.Cmd {
@@ -115,6 +130,9 @@ func (m spinnerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		if msg.String() == "q" || msg.String() == "ctrl+c" {
+			if m.onQuit != nil {
+				m.onQuit()
+			}
 			return m, tea.Quit
 		}
 	default:
@@ -217,7 +235,7 @@ func (m configModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "tab":
 			if m.page == "provider" {
 				// Cycle through providers
-				providers := []string{"local", "gemini", "openai", "anthropic", "custom"}
+				providers := []string{"local", "gemini", "openai", "anthropic", "custom", "ollama"}
 				for i, p := range providers {
 					if p == m.currentProvider {
 						nextIndex := (i + 1) % len(providers)
@@ -242,7 +260,7 @@ func (m configModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "shift+tab":
 			if m.page == "provider" {
 				// Cycle through providers backward
-				providers := []string{"local", "gemini", "openai", "anthropic", "custom"}
+				providers := []string{"local", "gemini", "openai", "anthropic", "custom", "ollama"}
 				for i, p := range providers {
 					if p == m.currentProvider {
 						nextIndex := (i - 1)
@@ -271,10 +289,10 @@ func (m configModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return m, nil
 
-		case "1", "2", "3", "4", "5":
+		case "1", "2", "3", "4", "5", "6":
 			if m.page == "provider" {
 				// Direct provider selection using number keys
-				providers := []string{"local", "gemini", "openai", "anthropic", "custom"}
+				providers := []string{"local", "gemini", "openai", "anthropic", "custom", "ollama"}
 				idx := int(msg.Runes[0] - '1')
 				if idx >= 0 && idx < len(providers) {
 					m.currentProvider = providers[idx]
@@ -314,13 +332,14 @@ func (m configModel) renderProviderSelection() string {
 	s := titleStyle.Render("ROASTME CONFIGURATION") + "\n\n"
 	s += promptStyle.Render("Select AI Provider:") + "\n\n"
 
-	providers := []string{"local", "gemini", "openai", "anthropic", "custom"}
+	providers := []string{"local", "gemini", "openai", "anthropic", "custom", "ollama"}
 	descriptions := map[string]string{
 		"local":     "No API key required - uses built-in rules",
 		"gemini":    "Google's Gemini AI (API key required)",
 		"openai":    "OpenAI's GPT models (API key required)",
 		"anthropic": "Anthropic's Claude models (API key required)",
-		"custom":    "Custom LLM provider (API key required)",
+		"custom":    "OpenAI-compatible server or any HTTP endpoint",
+		"ollama":    "Local models via Ollama (works offline)",
 	}
 
 	for i, provider := range providers {
@@ -390,6 +409,12 @@ func (m configModel) renderSettingsPage() string {
 				} else if i == 2 {
 					label = "Model:"
 				}
+			case "ollama":
+				if i == 0 {
+					label = "Host:"
+				} else if i == 1 {
+					label = "Model:"
+				}
 			}
 
 			// Add cursor indicator for focused input
@@ -505,10 +530,10 @@ func (m *configModel) setupInputsForCurrentProvider() {
 
 		// Model
 		modelInput := textinput.New()
-		modelInput.Placeholder = "claude-2"
+		modelInput.Placeholder = "claude-3-5-haiku-latest"
 		modelInput.SetValue(m.cfg.AI.Anthropic.Model)
 		if modelInput.Value() == "" {
-			modelInput.SetValue("claude-2")
+			modelInput.SetValue("claude-3-5-haiku-latest")
 		}
 
 		m.inputs = append(m.inputs, apiKeyInput, modelInput)
@@ -535,6 +560,26 @@ func (m *configModel) setupInputsForCurrentProvider() {
 
 		m.inputs = append(m.inputs, apiKeyInput, baseURLInput, modelInput)
 
+	case "ollama":
+		// Host
+		hostInput := textinput.New()
+		hostInput.Placeholder = "http://localhost:11434"
+		hostInput.SetValue(m.cfg.AI.Ollama.BaseURL)
+		hostInput.Width = 60
+		if hostInput.Value() == "" {
+			hostInput.SetValue("http://localhost:11434")
+		}
+
+		// Model
+		modelInput := textinput.New()
+		modelInput.Placeholder = "llama3.2"
+		modelInput.SetValue(m.cfg.AI.Ollama.Model)
+		if modelInput.Value() == "" {
+			modelInput.SetValue("llama3.2")
+		}
+
+		m.inputs = append(m.inputs, hostInput, modelInput)
+
 	case "local":
 		// No inputs needed for local
 	}
@@ -574,6 +619,9 @@ func (m *configModel) saveConfig() {
 				m.cfg.AI.Custom.BaseURL = m.inputs[1].Value()
 				m.cfg.AI.Custom.Model = m.inputs[2].Value()
 			}
+		case "ollama":
+			m.cfg.AI.Ollama.BaseURL = m.inputs[0].Value()
+			m.cfg.AI.Ollama.Model = m.inputs[1].Value()
 		}
 	}
 
@@ -590,23 +638,151 @@ func (m *configModel) saveConfig() {
 }
 
 // DisplayInteractiveRoast displays the roast in the interactive mode
-func DisplayInteractiveRoast(roast string, count int, commandCount int) {
+func DisplayInteractiveRoast(roast ai.Roast, count int, commandCount int) {
 	// Clear screen
 	fmt.Print("\033[H\033[2J")
 
-	// Header with roast count and command count
+	printRoastHeader(count, commandCount)
+	if roast.Provider != "" && roast.Provider != "local" {
+		provider := roast.Provider
+		if roast.Model != "" {
+			provider += " (" + roast.Model + ")"
+		}
+		fmt.Println(infoStyle.Render("Roasted by " + provider))
+	}
+	fmt.Println()
+
+	// Make it obvious when a provider failed and something else answered
+	if roast.Fallback != nil {
+		notice := "AI provider failed, showing a built-in roast instead: "
+		if roast.Provider != "local" {
+			notice = "Fell back to " + roast.Provider + " after: "
+		}
+		fmt.Println(errorStyle.Render(notice + roast.Fallback.Error()))
+		if hint := ai.ErrorHint(roast.Fallback); hint != "" {
+			fmt.Println(infoStyle.Render(hint))
+		}
+		fmt.Println()
+	}
+
+	// Roast content in a fancy box
+	fmt.Println(roastBoxStyle.Render(strings.TrimSpace(roast.Text)))
+	fmt.Println()
+
+	// Footer
+	fmt.Println(infoStyle.Render("Press Enter for another roast, or type a comeback or question"))
+	fmt.Println(infoStyle.Render("Type 'exit' or press Ctrl+C to quit"))
+}
+
+// printRoastHeader prints the roast count and how many commands were analyzed
+func printRoastHeader(count int, commandCount int) {
 	headerText := fmt.Sprintf("ROAST #%d", count)
 	header := strings.Repeat("─", (70-len(headerText))/2) + headerText + strings.Repeat("─", (70-len(headerText))/2)
 
 	fmt.Println(titleStyle.Render(header))
 	fmt.Println(infoStyle.Render(fmt.Sprintf("Analyzed %d commands from your history", commandCount)))
-	fmt.Println()
+}
 
-	// Roast content in a fancy box
-	fmt.Println(roastBoxStyle.Render(strings.TrimSpace(roast)))
+// streamRedrawInterval limits how often a streaming roast is redrawn, since
+// redrawing on every token makes the terminal flicker
+const streamRedrawInterval = 50 * time.Millisecond
+
+// RoastStream draws a roast into the roast box while it is still being generated.
+// DisplayInteractiveRoast draws the finished roast over it.
+type RoastStream struct {
+	count        int
+	commandCount int
+	lastDraw     time.Time
+}
+
+// NewRoastStream creates a stream renderer for the given roast number
+func NewRoastStream(count int, commandCount int) *RoastStream {
+	return &RoastStream{count: count, commandCount: commandCount}
+}
+
+// Update redraws the roast box with the text received so far
+func (s *RoastStream) Update(text string) {
+	if text != "" && time.Since(s.lastDraw) < streamRedrawInterval {
+		return
+	}
+	s.lastDraw = time.Now()
+
+	// Move home and clear downwards rather than clearing the screen, which flickers less
+	fmt.Print("\033[H\033[J")
+
+	printRoastHeader(s.count, s.commandCount)
+	fmt.Println()
+	fmt.Println(roastBoxStyle.Render(strings.TrimSpace(text) + "▌"))
 	fmt.Println()
+	fmt.Println(infoStyle.Render("Press Ctrl+C to stop"))
+}
 
-	// Footer
-	fmt.Println(infoStyle.Render("Press Enter for another roast"))
-	fmt.Println(infoStyle.Render("Type 'exit' or press Ctrl+C to quit"))
+// DisplayRedactions shows which commands would be changed by redaction before being sent to an AI provider
+func DisplayRedactions(previews []redact.Preview, commandCount int, enabled bool) {
+	fmt.Println(titleStyle.Render("───── REDACTION PREVIEW ─────"))
+
+	if !enabled {
+		fmt.Println(errorStyle.Render("Redaction is disabled in your config; commands would be sent unchanged."))
+		fmt.Println()
+	}
+
+	if len(previews) == 0 {
+		fmt.Println(successStyle.Render(fmt.Sprintf("Nothing to redact in %d commands.", commandCount)))
+		return
+	}
+
+	for _, preview := range previews {
+		fmt.Println(errorStyle.Render("- ") + preview.Original)
+		fmt.Println(successStyle.Render("+ ") + preview.Redacted)
+
+		rules := make([]string, 0, len(preview.Redactions))
+		for _, r := range preview.Redactions {
+			rules = append(rules, r.Rule)
+		}
+		fmt.Println(infoStyle.Render("  " + strings.Join(rules, ", ")))
+		fmt.Println()
+	}
+
+	summary := redact.Summarize(previews)
+	parts := make([]string, 0, len(summary))
+	for _, rc := range summary {
+		parts = append(parts, fmt.Sprintf("%s: %d", rc.Rule, rc.Count))
+	}
+	fmt.Println(highlightStyle.Render(fmt.Sprintf("%d of %d commands would be redacted", len(previews), commandCount)))
+	fmt.Println(infoStyle.Render(strings.Join(parts, ", ")))
+}
+
+// DisplayDryRun shows the request that would be sent to the AI provider
+func DisplayDryRun(req ai.Request) {
+	fmt.Println(titleStyle.Render("───── DRY RUN ─────"))
+
+	provider := req.Provider
+	if req.Model != "" {
+		provider += " (" + req.Model + ")"
+	}
+	fmt.Println(promptStyle.Render("Provider:      ") + provider)
+	fmt.Println(promptStyle.Render("Persona:       ") + req.Persona)
+	fmt.Println(promptStyle.Render("Max tokens:    ") + fmt.Sprint(req.MaxTokens))
+	fmt.Println(promptStyle.Render("Temperature:   ") + fmt.Sprint(req.Temperature))
+	fmt.Println(promptStyle.Render("Commands sent: ") + fmt.Sprintf("%d sampled of %d analyzed (--seed %d)", len(req.SampledCommands), req.TotalCommands, req.Seed))
+	if req.ContextSize > 0 && req.Provider != "" && req.Provider != "local" {
+		fmt.Println(promptStyle.Render("Prompt tokens: ") + fmt.Sprintf("~%d of a %d token context", req.PromptTokens, req.ContextSize))
+	}
+	if req.Redacted {
+		fmt.Println(promptStyle.Render("Redaction:     ") + "enabled")
+	} else {
+		fmt.Println(promptStyle.Render("Redaction:     ") + errorStyle.Render("disabled"))
+	}
+
+	if req.Provider == "" || req.Provider == "local" {
+		fmt.Println()
+		fmt.Println(successStyle.Render("The local provider never makes network calls; nothing below would leave your machine."))
+	}
+
+	fmt.Println()
+	fmt.Println(highlightStyle.Render("System prompt:"))
+	fmt.Println(req.SystemPrompt)
+	fmt.Println()
+	fmt.Println(highlightStyle.Render("Prompt:"))
+	fmt.Println(req.Prompt)
 }